@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/vcfgo"
+	"github.com/google/subcommands"
+)
+
+type acmg struct {
+	infos infoFields
+}
+
+func (*acmg) Name() string { return "acmg" }
+func (*acmg) Synopsis() string {
+	return "add automatable ACMG/AMP evidence codes and a five tier classification"
+}
+func (*acmg) Usage() string {
+	return `acmg [-overwrite | -suffix _2] < in.vcf > out.vcf
+
+Reads the INFO fields rank uses and writes acmg_codes and acmg:
+  PVS1     vep_IMPACT HIGH in a constrained gene (gnomAD_pLI >= 0.5)
+  PM2      popmax AF <= 0.0001 and TOPMed_AF < 0.001, or absent
+  PP3      missense with REVEL_score >= 0.644 or CADD_phred >= 25, or spliceAI_max >= 0.2
+  BP4      all of REVEL_score <= 0.29, CADD_phred < 15, spliceAI_max < 0.1 that are present
+  BA1/BS1  popmax AF > 0.05 / > 0.01
+  PS2/PM6  hq_denovo / denovo
+  PM3      slivar_comphet
+PP3 and BP4 are not used alongside PVS1.
+`
+}
+
+func (a *acmg) SetFlags(f *flag.FlagSet) {
+	a.infos.setFlags(f)
+}
+
+func infoFloatOK(v *vcfgo.Variant, key string) (float64, bool) {
+	f, err := strconv.ParseFloat(infoFirst(v, key), 64)
+	return f, err == nil
+}
+
+// acmgCodes returns the criteria the variant meets
+func (rk *ranker) acmgCodes(v *vcfgo.Variant) []string {
+	var codes []string
+	add := func(code string, ok bool) bool {
+		if ok {
+			codes = append(codes, code)
+		}
+		return ok
+	}
+
+	pvs1 := add("PVS1", rk.isLGD(v) && rk.isConstrained(v))
+	add("PM2", rk.isRare(v))
+
+	af := getGnomAD(v)
+	if !add("BA1", af > 0.05) {
+		add("BS1", af > 0.01)
+	}
+
+	if !pvs1 {
+		revel, hasRevel := infoFloatOK(v, "REVEL_score")
+		cadd, hasCadd := infoFloatOK(v, "CADD_phred")
+		splice, hasSplice := infoFloatOK(v, "spliceAI_max")
+		missense := infoFirst(v, "vep_Consequence") == "missense_variant"
+
+		pp3 := add("PP3", missense && (hasRevel && revel >= 0.644 || hasCadd && cadd >= 25) || hasSplice && splice >= 0.2)
+		if !pp3 && (hasRevel || hasCadd || hasSplice) {
+			add("BP4", (!hasRevel || revel <= 0.29) && (!hasCadd || cadd < 15) && (!hasSplice || splice < 0.1))
+		}
+	}
+
+	if !add("PS2", infoFirst(v, "hq_denovo") != "") {
+		add("PM6", infoFirst(v, "denovo") != "")
+	}
+	add("PM3", infoFirst(v, "slivar_comphet") != "")
+
+	return codes
+}
+
+// acmgClassify combines codes with the Richards et al. 2015 rules. Variants
+// with both pathogenic and benign classifications are uncertain.
+func acmgClassify(codes []string) string {
+	n := map[string]int{}
+	for _, c := range codes {
+		n[strings.TrimRight(c, "0123456789")]++
+	}
+	pvs, ps, pm, pp := n["PVS"], n["PS"], n["PM"], n["PP"]
+	ba, bs, bp := n["BA"], n["BS"], n["BP"]
+
+	pathogenic := pvs >= 1 && (ps >= 1 || pm >= 2 || pm == 1 && pp == 1 || pp >= 2) ||
+		ps >= 2 ||
+		ps == 1 && (pm >= 3 || pm == 2 && pp >= 2 || pm == 1 && pp >= 4)
+	likelyPathogenic := pvs >= 1 && pm == 1 ||
+		ps == 1 && (pm == 1 || pm == 2) ||
+		ps == 1 && pp >= 2 ||
+		pm >= 3 ||
+		pm == 2 && pp >= 2 ||
+		pm == 1 && pp >= 4
+	benign := ba >= 1 || bs >= 2
+	likelyBenign := bs == 1 && bp >= 1 || bp >= 2
+
+	isPath := pathogenic || likelyPathogenic
+	isBenign := benign || likelyBenign
+	switch {
+	case isPath && isBenign:
+		return "Uncertain_significance"
+	case pathogenic:
+		return "Pathogenic"
+	case likelyPathogenic:
+		return "Likely_pathogenic"
+	case benign:
+		return "Benign"
+	case likelyBenign:
+		return "Likely_benign"
+	}
+	return "Uncertain_significance"
+}
+
+func (a *acmg) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := statsFrom(args)
+	ep := errPolicyFrom(args)
+	rdr, err := vcfgo.NewReader(os.Stdin, false)
+	if err != nil {
+		return ep.fatal(err)
+	}
+
+	if err := a.infos.add(rdr, "acmg_codes", ".", "String", "ACMG/AMP criteria met, from vcfUtils acmg"); err != nil {
+		return ep.fatal(err)
+	}
+	if err := a.infos.add(rdr, "acmg", "1", "String", "ACMG/AMP classification from acmg_codes (Pathogenic, Likely_pathogenic, Uncertain_significance, Likely_benign, Benign)"); err != nil {
+		return ep.fatal(err)
+	}
+
+	addProvenance(rdr.Header, ep.cmd)
+	wrt, err := vcfgo.NewWriter(os.Stdout, rdr.Header)
+	if err != nil {
+		return ep.fatal(err)
+	}
+
+	rk := &ranker{}
+	for {
+		variant := rdr.Read()
+		if variant == nil {
+			break
+		}
+		st.RecordsIn++
+		if err := readErr(rdr); err != nil {
+			if !ep.record(variantWhere(variant), err) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+
+		a.infos.clear(variant)
+		codes := rk.acmgCodes(variant)
+		if len(codes) > 0 {
+			variant.Info().Set(a.infos.id("acmg_codes"), strings.Join(codes, ","))
+		}
+		class := acmgClassify(codes)
+		variant.Info().Set(a.infos.id("acmg"), class)
+		st.count(class)
+		st.RecordsOut++
+		wrt.WriteVariant(variant)
+	}
+	return subcommands.ExitSuccess
+}
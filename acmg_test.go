@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/brentp/vcfgo"
+)
+
+func TestACMGPopulationCodes(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		info testInfo
+		want string
+	}{
+		{"absent from gnomAD and TOPMed", testInfo{}, "PM2"},
+		{"rare in both", testInfo{"eAF_popmax": 0.00001, "TOPMed_AF": 0.0005}, "PM2"},
+		{"absent from gnomAD, common in TOPMed", testInfo{"TOPMed_AF": 0.002}, ""},
+		{"gnomAD genomes only", testInfo{"gAF_popmax": 0.02}, "BS1"},
+		{"common", testInfo{"eAF_popmax": 0.1}, "BA1"},
+	} {
+		v := &vcfgo.Variant{Chromosome: "1", Pos: 100, Reference: "A", Alternate: []string{"G"}, Info_: tc.info}
+		rk := &ranker{}
+		if got := strings.Join(rk.acmgCodes(v), ","); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
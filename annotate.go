@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/vcfgo"
+	"github.com/google/subcommands"
+)
+
+type annConfig struct {
+	Sources []*annSourceConfig `json:"sources"`
+}
+
+type annSourceConfig struct {
+	File string `json:"file"`
+	// vcf, bed or tsv, from the file extension when empty
+	Type string `json:"type"`
+	// INFO keys, 1 based BED columns or TSV header columns
+	Fields []string `json:"fields"`
+	Names  []string `json:"names"`
+	Ops    []string `json:"ops"`
+	Types  []string `json:"types"`
+	// tsv only, the gene column and the INFO field it is matched against
+	Key   string `json:"key"`
+	Match string `json:"match"`
+
+	src     annSource
+	numbers map[string]string
+	genes   map[string]map[string]string
+}
+
+var annOps = map[string]bool{"first": true, "max": true, "min": true, "concat": true}
+
+func annSourceType(path string) string {
+	p := strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(path), ".gz"), ".bgz")
+	switch filepath.Ext(p) {
+	case ".vcf":
+		return "vcf"
+	case ".bcf":
+		return "bcf"
+	case ".bed":
+		return "bed"
+	}
+	return "tsv"
+}
+
+func readAnnConfig(path string) (*annConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &annConfig{}
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	for _, s := range cfg.Sources {
+		if s.File == "" || len(s.Fields) == 0 {
+			return nil, fmt.Errorf("%s: every source needs file and fields", path)
+		}
+		if s.Type == "" {
+			s.Type = annSourceType(s.File)
+		}
+		if s.Type == "bcf" {
+			return nil, fmt.Errorf("%s: %s: bcf is not supported, convert it with bcftools view -Oz and index it with tabix", path, s.File)
+		}
+		for _, l := range []*[]string{&s.Names, &s.Ops, &s.Types} {
+			if len(*l) != 0 && len(*l) != len(s.Fields) {
+				return nil, fmt.Errorf("%s: %s: names, ops and types need one entry per field", path, s.File)
+			}
+		}
+		if len(s.Names) == 0 {
+			if s.Type == "bed" {
+				return nil, fmt.Errorf("%s: %s: bed sources need names, column numbers are not INFO IDs", path, s.File)
+			}
+			s.Names = s.Fields
+		}
+		if len(s.Ops) == 0 {
+			for range s.Fields {
+				s.Ops = append(s.Ops, "first")
+			}
+		}
+		for _, op := range s.Ops {
+			if !annOps[op] {
+				return nil, fmt.Errorf("%s: %s: unknown op %q, use first, max, min or concat", path, s.File, op)
+			}
+		}
+		if len(s.Types) == 0 {
+			for _, op := range s.Ops {
+				if op == "max" || op == "min" {
+					s.Types = append(s.Types, "Float")
+				} else {
+					s.Types = append(s.Types, "String")
+				}
+			}
+		}
+		if s.Match == "" {
+			s.Match = "vep_SYMBOL"
+		}
+	}
+	return cfg, nil
+}
+
+var infoNumberRe = regexp.MustCompile(`^##INFO=<ID=([^,>]+),Number=([^,>]+)`)
+
+func vcfRecordParser(fields []string) func(string) (*annRecord, error) {
+	want := map[string]bool{}
+	for _, f := range fields {
+		want[f] = true
+	}
+	return func(line string) (*annRecord, error) {
+		ls := strings.SplitN(line, "\t", 9)
+		if len(ls) < 8 {
+			return nil, fmt.Errorf("short vcf line %q", line)
+		}
+		pos, err := strconv.Atoi(ls[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad position %q", ls[1])
+		}
+		rec := &annRecord{chrom: ls[0], start: pos - 1, end: pos - 1 + len(ls[3]), ref: ls[3],
+			alts: strings.Split(ls[4], ","), vals: map[string]string{}}
+		for _, kv := range strings.Split(ls[7], ";") {
+			k, val, ok := strings.Cut(kv, "=")
+			if !want[k] {
+				continue
+			}
+			if !ok {
+				val = "true"
+			}
+			rec.vals[k] = val
+		}
+		return rec, nil
+	}
+}
+
+func bedRecordParser(fields []string) (func(string) (*annRecord, error), error) {
+	cols := map[string]int{}
+	for _, f := range fields {
+		c, err := strconv.Atoi(f)
+		if err != nil || c < 4 {
+			return nil, fmt.Errorf("bed fields are column numbers from 4, not %q", f)
+		}
+		cols[f] = c - 1
+	}
+	return func(line string) (*annRecord, error) {
+		ls := strings.Split(line, "\t")
+		if len(ls) < 3 {
+			return nil, fmt.Errorf("short bed line %q", line)
+		}
+		start, err1 := strconv.Atoi(ls[1])
+		end, err2 := strconv.Atoi(ls[2])
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("bad bed interval %q", line)
+		}
+		rec := &annRecord{chrom: ls[0], start: start, end: end, vals: map[string]string{}}
+		for f, c := range cols {
+			if c < len(ls) {
+				rec.vals[f] = ls[c]
+			}
+		}
+		return rec, nil
+	}, nil
+}
+
+// readGeneTable keys the rows of a tsv with a header line by upper cased gene
+func readGeneTable(path, key string) (map[string]map[string]string, error) {
+	rc, err := openMaybeGzip(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var b []string
+	scanner := bufio.NewScanner(rc)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			b = append(b, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("%s: empty", path)
+	}
+
+	header := strings.Split(strings.TrimPrefix(b[0], "#"), "\t")
+	keyCol := 0
+	if key != "" {
+		keyCol = -1
+		for i, h := range header {
+			if h == key {
+				keyCol = i
+			}
+		}
+		if keyCol < 0 {
+			return nil, fmt.Errorf("%s: no column %q", path, key)
+		}
+	}
+
+	genes := map[string]map[string]string{}
+	for _, line := range b[1:] {
+		ls := strings.Split(line, "\t")
+		if keyCol >= len(ls) {
+			continue
+		}
+		row := map[string]string{}
+		for i, h := range header {
+			if i < len(ls) {
+				row[h] = ls[i]
+			}
+		}
+		g := strings.ToUpper(ls[keyCol])
+		if _, ok := genes[g]; !ok {
+			genes[g] = row
+		}
+	}
+	return genes, nil
+}
+
+func (s *annSourceConfig) open() error {
+	var err error
+	switch s.Type {
+	case "vcf":
+		var header []string
+		s.src, header, err = openAnnSource(s.File, vcfRecordParser(s.Fields))
+		if err != nil {
+			return err
+		}
+		s.numbers = map[string]string{}
+		for _, h := range header {
+			if m := infoNumberRe.FindStringSubmatch(h); m != nil {
+				s.numbers[m[1]] = m[2]
+			}
+		}
+	case "bed":
+		parse, err := bedRecordParser(s.Fields)
+		if err != nil {
+			return fmt.Errorf("%s: %s", s.File, err)
+		}
+		s.src, _, err = openAnnSource(s.File, parse)
+		return err
+	case "tsv":
+		s.genes, err = readGeneTable(s.File, s.Key)
+		return err
+	default:
+		return fmt.Errorf("%s: unknown source type %q", s.File, s.Type)
+	}
+	return nil
+}
+
+// values collects every value of each field that matches the variant, by
+// allele for vcf, by overlap for bed and by gene for tsv
+func (s *annSourceConfig) values(v *vcfgo.Variant) ([][]string, error) {
+	vals := make([][]string, len(s.Fields))
+	add := func(i int, x string) {
+		if x != "" && x != "." {
+			vals[i] = append(vals[i], x)
+		}
+	}
+
+	if s.Type == "tsv" {
+		for _, g := range strings.Split(infoFirst(v, s.Match), ",") {
+			if row, ok := s.genes[strings.ToUpper(g)]; ok {
+				for i, f := range s.Fields {
+					add(i, row[f])
+				}
+			}
+		}
+		return vals, nil
+	}
+
+	start := int(v.Pos) - 1
+	recs, err := s.src.query(v.Chromosome, start, start+len(v.Reference))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", s.File, err)
+	}
+	for _, rec := range recs {
+		if s.Type == "bed" {
+			for i, f := range s.Fields {
+				add(i, rec.vals[f])
+			}
+			continue
+		}
+		if rec.start != start || rec.ref != v.Reference {
+			continue
+		}
+		for ai, alt := range rec.alts {
+			for _, valt := range v.Alternate {
+				if alt != valt {
+					continue
+				}
+				for i, f := range s.Fields {
+					add(i, alleleValue(rec.vals[f], s.numbers[f], ai))
+				}
+			}
+		}
+	}
+	return vals, nil
+}
+
+// alleleValue picks the allele's entry of a Number=A or Number=R value
+func alleleValue(val, number string, ai int) string {
+	var i int
+	switch number {
+	case "A":
+		i = ai
+	case "R":
+		i = ai + 1
+	default:
+		return val
+	}
+	vals := strings.Split(val, ",")
+	if i >= len(vals) {
+		return ""
+	}
+	return vals[i]
+}
+
+func combineValues(op string, vals []string) (string, bool) {
+	if len(vals) == 0 {
+		return "", false
+	}
+	switch op {
+	case "max", "min":
+		var out float64
+		found := false
+		for _, val := range vals {
+			for _, x := range strings.Split(val, ",") {
+				f, err := strconv.ParseFloat(x, 64)
+				if err != nil {
+					continue
+				}
+				if !found || op == "max" && f > out || op == "min" && f < out {
+					out, found = f, true
+				}
+			}
+		}
+		return fmtNum(out), found
+	case "concat":
+		var uniq []string
+		seen := map[string]bool{}
+		for _, val := range vals {
+			if !seen[val] {
+				seen[val] = true
+				uniq = append(uniq, val)
+			}
+		}
+		return strings.Join(uniq, ","), true
+	}
+	return vals[0], true
+}
+
+type annotate struct {
+	config string
+	infos  infoFields
+}
+
+func (*annotate) Name() string { return "annotate" }
+func (*annotate) Synopsis() string {
+	return "add INFO fields from local vcf, bed and gene keyed tsv files"
+}
+func (*annotate) Usage() string {
+	return `annotate -config sources.json [-overwrite | -suffix _2] < in.vcf > out.vcf
+
+  {"sources": [
+    {"file": "gnomad.exomes.vcf.bgz", "fields": ["AF_popmax"], "names": ["eAF_popmax"], "ops": ["max"]},
+    {"file": "topmed.vcf.gz", "fields": ["AF"], "names": ["TOPMed_AF"], "ops": ["max"]},
+    {"file": "spliceai.bed.gz", "fields": ["5"], "names": ["spliceAI_max"], "ops": ["max"]},
+    {"file": "constraint.tsv", "key": "gene", "fields": ["pLI"], "names": ["gnomAD_pLI"]}
+  ]}
+
+vcf sources match on allele, bed sources on overlap (fields are column
+numbers and names are required) and tsv sources on the gene in match
+(default vep_SYMBOL), key naming the tsv gene column. Sorted bgzipped files
+with a .tbi index are read by position, others are loaded into memory. bcf
+is not supported, convert it to bgzipped vcf first. ops are first, max, min
+or concat, types default to Float for max and min and String otherwise.
+`
+}
+
+func (a *annotate) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&a.config, "config", "", "json file listing the annotation sources")
+	a.infos.setFlags(f)
+}
+
+func (a *annotate) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := statsFrom(args)
+	ep := errPolicyFrom(args)
+	cfg, err := readAnnConfig(a.config)
+	if err != nil {
+		return ep.fatal(err)
+	}
+	for _, s := range cfg.Sources {
+		if err := s.open(); err != nil {
+			return ep.fatal(err)
+		}
+	}
+
+	rdr, err := vcfgo.NewReader(os.Stdin, false)
+	if err != nil {
+		return ep.fatal(err)
+	}
+	for _, s := range cfg.Sources {
+		for i, name := range s.Names {
+			number := "1"
+			if s.Ops[i] == "concat" {
+				number = "."
+			}
+			if err := a.infos.add(rdr, name, number, s.Types[i], fmt.Sprintf("%s of %s from %s", s.Ops[i], s.Fields[i], filepath.Base(s.File))); err != nil {
+				return ep.fatal(err)
+			}
+		}
+	}
+
+	addProvenance(rdr.Header, ep.cmd)
+	wrt, err := vcfgo.NewWriter(os.Stdout, rdr.Header)
+	if err != nil {
+		return ep.fatal(err)
+	}
+
+	for {
+		variant := rdr.Read()
+		if variant == nil {
+			break
+		}
+		st.RecordsIn++
+		if err := readErr(rdr); err != nil {
+			if !ep.record(variantWhere(variant), err) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+
+		a.infos.clear(variant)
+		var bad error
+		for _, s := range cfg.Sources {
+			vals, err := s.values(variant)
+			if err != nil {
+				bad = err
+				break
+			}
+			for i, name := range s.Names {
+				val, ok := combineValues(s.Ops[i], vals[i])
+				if !ok {
+					continue
+				}
+				st.count(name)
+				switch s.Types[i] {
+				case "Float":
+					if x, err := strconv.ParseFloat(val, 64); err == nil {
+						variant.Info().Set(a.infos.id(name), x)
+						continue
+					}
+				case "Integer":
+					if x, err := strconv.Atoi(val); err == nil {
+						variant.Info().Set(a.infos.id(name), x)
+						continue
+					}
+				}
+				variant.Info().Set(a.infos.id(name), val)
+			}
+		}
+		if bad != nil {
+			if !ep.record(variantWhere(variant), bad) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+		st.RecordsOut++
+		wrt.WriteVariant(variant)
+	}
+	return subcommands.ExitSuccess
+}
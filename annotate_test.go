@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadAnnConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name, config, want string
+	}{
+		{"bcf", `{"sources": [{"file": "gnomad.bcf", "fields": ["AF"]}]}`, "bcf is not supported"},
+		{"bed without names", `{"sources": [{"file": "scores.bed.gz", "fields": ["5"]}]}`, "bed sources need names"},
+		{"bed with names", `{"sources": [{"file": "scores.bed.gz", "fields": ["5"], "names": ["score"]}]}`, ""},
+		{"vcf names default to fields", `{"sources": [{"file": "topmed.vcf.gz", "fields": ["AF"]}]}`, ""},
+	} {
+		path := filepath.Join(t.TempDir(), "sources.json")
+		if err := os.WriteFile(path, []byte(tc.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := readAnnConfig(path)
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("%s: %s", tc.name, err)
+		case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+			t.Errorf("%s: got %v, want %q", tc.name, err, tc.want)
+		case err == nil && len(cfg.Sources[0].Names) != 1:
+			t.Errorf("%s: names %q", tc.name, cfg.Sources[0].Names)
+		}
+	}
+}
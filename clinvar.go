@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/brentp/vcfgo"
+	"github.com/google/subcommands"
+)
+
+type clinvarRecord struct {
+	id      string
+	sig     string
+	revStat string
+}
+
+// clinvarStars converts CLNREVSTAT to the ClinVar review star rating
+func clinvarStars(revStat string) int {
+	switch {
+	case strings.Contains(revStat, "practice_guideline"):
+		return 4
+	case strings.Contains(revStat, "reviewed_by_expert_panel"):
+		return 3
+	case strings.Contains(revStat, "multiple_submitters") && strings.Contains(revStat, "no_conflicts"):
+		return 2
+	case strings.HasPrefix(revStat, "criteria_provided"):
+		return 1
+	}
+	return 0
+}
+
+// bareChrom drops the chr prefix so chr1 and 1 name the same contig
+func bareChrom(chrom string) string {
+	chrom = strings.TrimPrefix(chrom, "chr")
+	if chrom == "M" {
+		return "MT"
+	}
+	return chrom
+}
+
+// openClinVar opens a ClinVar vcf for region queries, through its tabix
+// index when it has one, keeping CLNSIG, CLNREVSTAT and the ID column
+func openClinVar(path string) (annSource, error) {
+	parse := vcfRecordParser([]string{"CLNSIG", "CLNREVSTAT"})
+	src, _, err := openAnnSource(path, func(line string) (*annRecord, error) {
+		rec, err := parse(line)
+		if err != nil {
+			return nil, err
+		}
+		rec.vals["ID"] = strings.SplitN(line, "\t", 4)[2]
+		return rec, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return src, nil
+}
+
+// lookupClinVar finds the ClinVar record of the first alt of v that ClinVar
+// has at the same position and REF
+func lookupClinVar(src annSource, v *vcfgo.Variant) (clinvarRecord, bool, error) {
+	start := int(v.Pos) - 1
+	recs, err := src.query(v.Chromosome, start, start+len(v.Reference))
+	if err != nil {
+		return clinvarRecord{}, false, err
+	}
+	for _, alt := range v.Alternate {
+		for _, r := range recs {
+			if r.start != start || r.ref != v.Reference {
+				continue
+			}
+			for _, a := range r.alts {
+				if a == alt && a != "." {
+					return clinvarRecord{id: r.vals["ID"], sig: r.vals["CLNSIG"], revStat: r.vals["CLNREVSTAT"]}, true, nil
+				}
+			}
+		}
+	}
+	return clinvarRecord{}, false, nil
+}
+
+// isClinVarPathogenic is true when every primary CLNSIG term is Pathogenic
+// or Likely_pathogenic, e.g. Pathogenic/Likely_pathogenic|risk_factor
+func isClinVarPathogenic(sig string) bool {
+	prim := strings.SplitN(sig, "|", 2)[0]
+	if prim == "" {
+		return false
+	}
+	for _, s := range strings.Split(prim, "/") {
+		switch strings.TrimSuffix(s, ",_low_penetrance") {
+		case "Pathogenic", "Likely_pathogenic":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// groupClinVar is the tier ahead of tier 1 for ClinVar P/LP with at least two stars
+func (rk *ranker) groupClinVar(v *vcfgo.Variant) bool {
+	sig := infoFirst(v, "clinvar_CLNSIG")
+	if !rk.ex.note("clinvarPathogenic", isClinVarPathogenic(sig), "clinvar_CLNSIG="+shown(v, "clinvar_CLNSIG")) {
+		return false
+	}
+	stars := infoFloat(v, "clinvar_stars")
+	return rk.ex.note("clinvarStars", stars >= 2, cmpDetail("clinvar_stars", shown(v, "clinvar_stars"), stars, 2))
+}
+
+type clinvar struct {
+	db    string
+	infos infoFields
+}
+
+func (*clinvar) Name() string { return "clinvar" }
+func (*clinvar) Synopsis() string {
+	return "annotate CLNSIG, review status and stars from a local ClinVar vcf"
+}
+func (*clinvar) Usage() string {
+	return `clinvar -db clinvar.vcf.gz [-overwrite | -suffix _2] < in.vcf > out.vcf
+
+Adds clinvar_id, clinvar_CLNSIG, clinvar_CLNREVSTAT and clinvar_stars. rank
+puts Pathogenic/Likely_pathogenic variants with 2 or more stars in tier 0.5.
+With a tabix index next to the ClinVar vcf each record's position is looked
+up through it, otherwise the whole file is loaded into memory.
+`
+}
+
+func (c *clinvar) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&c.db, "db", "", "ClinVar vcf, optionally bgzipped and tabix indexed")
+	c.infos.setFlags(f)
+}
+
+func (c *clinvar) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := statsFrom(args)
+	ep := errPolicyFrom(args)
+	if c.db == "" {
+		return ep.usage("-db is required")
+	}
+	db, err := openClinVar(c.db)
+	if err != nil {
+		return ep.fatal(err)
+	}
+
+	rdr, err := vcfgo.NewReader(os.Stdin, false)
+	if err != nil {
+		return ep.fatal(err)
+	}
+
+	for _, h := range [][4]string{
+		{"clinvar_id", "1", "String", "ClinVar variation ID"},
+		{"clinvar_CLNSIG", ".", "String", "ClinVar clinical significance"},
+		{"clinvar_CLNREVSTAT", ".", "String", "ClinVar review status"},
+		{"clinvar_stars", "1", "Integer", "ClinVar review stars, 0-4"},
+	} {
+		if err := c.infos.add(rdr, h[0], h[1], h[2], h[3]); err != nil {
+			return ep.fatal(err)
+		}
+	}
+
+	addProvenance(rdr.Header, ep.cmd)
+	wrt, err := vcfgo.NewWriter(os.Stdout, rdr.Header)
+	if err != nil {
+		return ep.fatal(err)
+	}
+
+	for {
+		variant := rdr.Read()
+		if variant == nil {
+			break
+		}
+		st.RecordsIn++
+		if err := readErr(rdr); err != nil {
+			if !ep.record(variantWhere(variant), err) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+		c.infos.clear(variant)
+
+		rec, ok, err := lookupClinVar(db, variant)
+		if err != nil {
+			if !ep.record(variantWhere(variant), err) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+		if ok {
+			variant.Info().Set(c.infos.id("clinvar_id"), rec.id)
+			st.count("matched")
+			if rec.sig != "" {
+				variant.Info().Set(c.infos.id("clinvar_CLNSIG"), rec.sig)
+			}
+			if rec.revStat != "" {
+				variant.Info().Set(c.infos.id("clinvar_CLNREVSTAT"), rec.revStat)
+			}
+			variant.Info().Set(c.infos.id("clinvar_stars"), clinvarStars(rec.revStat))
+		}
+		st.RecordsOut++
+		wrt.WriteVariant(variant)
+	}
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/brentp/vcfgo"
+)
+
+func TestLookupClinVar(t *testing.T) {
+	header := []string{"##fileformat=VCFv4.1", "#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO"}
+	lines := []string{
+		"1\t100\t11\tA\tG,T\t.\t.\tCLNSIG=Pathogenic;CLNREVSTAT=reviewed_by_expert_panel",
+		"1\t100\t12\tAC\tA\t.\t.\tCLNSIG=Benign;CLNREVSTAT=criteria_provided,_single_submitter",
+		"2\t5\t13\tG\tC\t.\t.\tCLNSIG=Likely_pathogenic",
+	}
+	path := writeTabixFixture(t, header, lines, nil)
+	src, err := openClinVar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := src.(*tabixSource); !ok {
+		t.Fatalf("got %T, want the tabix index used", src)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		chrom     string
+		pos       uint64
+		ref       string
+		alts      []string
+		id, sig   string
+		wantMatch bool
+	}{
+		{"second alt of a multiallelic", "chr1", 100, "A", []string{"T"}, "11", "Pathogenic", true},
+		{"first matching alt wins", "chr1", 100, "A", []string{"C", "G"}, "11", "Pathogenic", true},
+		{"same position other REF", "1", 100, "AC", []string{"A"}, "12", "Benign", true},
+		{"no CLNREVSTAT", "2", 5, "G", []string{"C"}, "13", "Likely_pathogenic", true},
+		{"other alt", "1", 100, "A", []string{"C"}, "", "", false},
+		{"overlapping but other position", "1", 101, "C", []string{"A"}, "", "", false},
+		{"unknown contig", "3", 100, "A", []string{"G"}, "", "", false},
+	} {
+		v := &vcfgo.Variant{Chromosome: tc.chrom, Pos: tc.pos, Reference: tc.ref, Alternate: tc.alts}
+		rec, ok, err := lookupClinVar(src, v)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if ok != tc.wantMatch || rec.id != tc.id || rec.sig != tc.sig {
+			t.Errorf("%s: got %v %+v, want %v id=%s sig=%s", tc.name, ok, rec, tc.wantMatch, tc.id, tc.sig)
+		}
+	}
+}
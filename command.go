@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	"github.com/google/subcommands"
+)
+
+// wrapped adds the flags every command shares, -stats and -on-error, and
+// hands the command its runStats and errPolicy through Execute's args
+type wrapped struct {
+	subcommands.Command
+	stats   string
+	onError string
+}
+
+func wrap(c subcommands.Command) subcommands.Command {
+	return &wrapped{Command: c}
+}
+
+func (w *wrapped) SetFlags(f *flag.FlagSet) {
+	w.Command.SetFlags(f)
+	f.StringVar(&w.stats, "stats", "", "write a json summary of the run (records in/out and command specific counts) to this file")
+	f.StringVar(&w.onError, "on-error", "fail", "what to do with a record that can't be processed: skip, warn (skip with a message) or fail")
+}
+
+func (w *wrapped) Execute(ctx context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := &runStats{Command: w.Name(), Counts: map[string]int{}}
+	ep := &errPolicy{cmd: w.Name(), mode: w.onError, st: st}
+	switch w.onError {
+	case "skip", "warn", "fail":
+	default:
+		return ep.usage("-on-error must be skip, warn or fail")
+	}
+
+	start := time.Now()
+	status := w.Command.Execute(ctx, f, append(args, st, ep)...)
+	if w.stats == "" {
+		return status
+	}
+
+	st.Seconds = time.Since(start).Seconds()
+	switch status {
+	case subcommands.ExitSuccess:
+		st.Status = "success"
+	case subcommands.ExitUsageError:
+		st.Status = "usage_error"
+	default:
+		st.Status = "failure"
+	}
+	if err := st.write(w.stats); err != nil {
+		return ep.fatal(err)
+	}
+	return status
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/vcfgo"
+)
+
+// constraintCutoff is a test like pLI>=0.5 or LOEUF<0.35
+type constraintCutoff struct {
+	metric string
+	op     string
+	cut    float64
+}
+
+var defaultConstrained = constraintCutoff{"gnomAD_pLI", ">=", 0.5}
+
+var constraintCutoffRe = regexp.MustCompile(`^\s*([A-Za-z0-9_.]+)\s*(>=|<=|>|<)\s*([-+0-9.eE]+)\s*$`)
+
+func parseConstraintCutoff(s string) (constraintCutoff, error) {
+	m := constraintCutoffRe.FindStringSubmatch(s)
+	if m == nil {
+		return constraintCutoff{}, fmt.Errorf("bad constraint cutoff %q, want e.g. pLI>=0.5 or LOEUF<0.35", s)
+	}
+	cut, err := strconv.ParseFloat(m[3], 64)
+	if err != nil {
+		return constraintCutoff{}, fmt.Errorf("bad constraint cutoff %q, want e.g. pLI>=0.5 or LOEUF<0.35", s)
+	}
+	return constraintCutoff{metric: m[1], op: m[2], cut: cut}, nil
+}
+
+func (c constraintCutoff) test(x float64) bool {
+	switch c.op {
+	case ">=":
+		return x >= c.cut
+	case ">":
+		return x > c.cut
+	case "<=":
+		return x <= c.cut
+	}
+	return x < c.cut
+}
+
+// constraintAliases are the gnomAD v2 and v4 column names of the usual metrics
+var constraintAliases = map[string][]string{
+	"pLI":   {"pLI", "lof.pLI"},
+	"LOEUF": {"oe_lof_upper", "lof.oe_ci.upper", "LOEUF"},
+	"mis_z": {"mis_z", "mis.z_score"},
+}
+
+// constraintTable is a gnomAD constraint tsv keyed by transcript (no
+// version) and by gene symbol and Ensembl gene ID, genes using their
+// canonical or MANE transcript row
+type constraintTable struct {
+	column       string
+	byTranscript map[string]string
+	byGene       map[string]string
+}
+
+func readConstraintTable(path, metric string) (*constraintTable, error) {
+	rc, err := openMaybeGzip(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	scanner := bufio.NewScanner(rc)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: empty", path)
+	}
+	cols := map[string]int{}
+	for i, h := range strings.Split(strings.TrimPrefix(scanner.Text(), "#"), "\t") {
+		cols[h] = i
+	}
+
+	t := &constraintTable{byTranscript: map[string]string{}, byGene: map[string]string{}}
+	names, ok := constraintAliases[metric]
+	if !ok {
+		names = []string{metric}
+	}
+	for _, n := range names {
+		if _, ok := cols[n]; ok {
+			t.column = n
+			break
+		}
+	}
+	if t.column == "" {
+		return nil, fmt.Errorf("%s: no %s column", path, metric)
+	}
+
+	get := func(ls []string, col string) string {
+		if i, ok := cols[col]; ok && i < len(ls) {
+			return ls[i]
+		}
+		return ""
+	}
+	preferred := map[string]bool{}
+	for scanner.Scan() {
+		ls := strings.Split(scanner.Text(), "\t")
+		val := get(ls, t.column)
+		if tx := get(ls, "transcript"); tx != "" {
+			t.byTranscript[stripVersion(tx)] = val
+		}
+		canon := get(ls, "canonical") == "true" || get(ls, "mane_select") == "true"
+		for _, g := range []string{get(ls, "gene"), get(ls, "gene_id")} {
+			g = strings.ToUpper(g)
+			if g == "" || preferred[g] {
+				continue
+			}
+			if _, seen := t.byGene[g]; !seen || canon {
+				t.byGene[g] = val
+				preferred[g] = canon
+			}
+		}
+	}
+	return t, scanner.Err()
+}
+
+// lookup joins on the transcript rank selected, vep_Feature, then its gene,
+// falling back to the most severe CSQ entry without pullCSQ fields
+func (t *constraintTable) lookup(v *vcfgo.Variant, csqKeys []string) (string, bool) {
+	tx, genes := infoFirst(v, "vep_Feature"), []string{infoFirst(v, "vep_Gene"), infoFirst(v, "vep_SYMBOL")}
+	if tx == "" && genes[0] == "" && genes[1] == "" && csqKeys != nil {
+		if acsq := getCSQ(v, csqKeys); len(acsq) > 0 {
+			c := rankSevere(acsq)
+			tx, genes = c["Feature"], []string{c["Gene"], c["SYMBOL"]}
+		}
+	}
+	if val, ok := t.byTranscript[stripVersion(tx)]; ok && tx != "" {
+		return val, true
+	}
+	for _, g := range genes {
+		if val, ok := t.byGene[strings.ToUpper(g)]; ok && g != "" {
+			return val, true
+		}
+	}
+	return "", false
+}
+
+// constraintValue is the metric from the table when one is loaded, or
+// else from INFO
+func (rk *ranker) constraintValue(v *vcfgo.Variant, c constraintCutoff) (float64, string, bool) {
+	s := infoFirst(v, c.metric)
+	if rk.constraint != nil {
+		s, _ = rk.constraint.lookup(v, rk.csqKeys)
+	}
+	x, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, ".", false
+	}
+	return x, s, true
+}
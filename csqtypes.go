@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// csqType is the INFO Type and Number pullCSQ declares an extracted field
+// with. Number . fields are written as lists split on &. max keeps the
+// largest of several values in a Number 1 Float field.
+type csqType struct {
+	typ    string
+	number string
+	max    bool
+}
+
+// csqListFields are the VEP fields that hold &-separated lists
+var csqListFields = map[string]bool{
+	"Consequence":           true,
+	"Existing_variation":    true,
+	"CLIN_SIG":              true,
+	"SOMATIC":               true,
+	"PHENO":                 true,
+	"PUBMED":                true,
+	"DOMAINS":               true,
+	"FLAGS":                 true,
+	"VAR_SYNONYMS":          true,
+	"MOTIF_NAME":            true,
+	"TRANSCRIPTION_FACTORS": true,
+}
+
+var (
+	csqFloatRe   = regexp.MustCompile(`(?i)(^|_)(AF|MAF|PHRED|RAW|score|rankscore)$|^SpliceAI_pred_DS_`)
+	csqIntegerRe = regexp.MustCompile(`^(DISTANCE|STRAND)$|^SpliceAI_pred_DP_`)
+)
+
+// inferCSQType guesses a field's type from its name. Scores and
+// frequencies are often given per transcript or allele as 0.5&0.6, so they
+// are reduced to their largest value.
+func inferCSQType(field string) csqType {
+	switch {
+	case csqListFields[field]:
+		return csqType{"String", ".", false}
+	case csqFloatRe.MatchString(field):
+		return csqType{"Float", "1", true}
+	case csqIntegerRe.MatchString(field):
+		return csqType{"Integer", "1", false}
+	}
+	return csqType{"String", "1", false}
+}
+
+// parseCSQTypes reads -types, e.g. REVEL_score:Float,DOMAINS:String:.
+func parseCSQTypes(s string) (map[string]csqType, error) {
+	types := map[string]csqType{}
+	if s == "" {
+		return types, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		ls := strings.Split(kv, ":")
+		if len(ls) < 2 || len(ls) > 3 {
+			return nil, fmt.Errorf("bad -types entry %q, want field:Type or field:Type:Number", kv)
+		}
+		t := csqType{typ: ls[1], number: "1"}
+		if len(ls) == 3 {
+			t.number = ls[2]
+		}
+		switch t.typ {
+		case "Integer", "Float", "String":
+		default:
+			return nil, fmt.Errorf("bad -types entry %q, Type must be Integer, Float or String", kv)
+		}
+		if t.number != "1" && t.number != "." {
+			return nil, fmt.Errorf("bad -types entry %q, Number must be 1 or .", kv)
+		}
+		types[ls[0]] = t
+	}
+	return types, nil
+}
+
+// value converts a CSQ value to the type's INFO value, nil when it is
+// empty. Empty and . items of a list are dropped.
+func (t csqType) value(s string) (interface{}, error) {
+	var items []string
+	for _, it := range strings.Split(s, "&") {
+		if it != "" && it != "." {
+			items = append(items, it)
+		}
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+	if t.number == "1" && len(items) > 1 {
+		switch {
+		case t.typ == "String":
+			return s, nil
+		case !t.max:
+			return nil, fmt.Errorf("%q has several values, give the field Number . in -types", s)
+		}
+	}
+
+	switch t.typ {
+	case "Float":
+		fs := make([]float64, len(items))
+		for i, it := range items {
+			f, err := strconv.ParseFloat(it, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a Float", it)
+			}
+			fs[i] = f
+		}
+		if t.number == "1" {
+			max := fs[0]
+			for _, f := range fs[1:] {
+				if f > max {
+					max = f
+				}
+			}
+			return max, nil
+		}
+		return fs, nil
+	case "Integer":
+		is := make([]int, len(items))
+		for i, it := range items {
+			n, err := strconv.Atoi(it)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not an Integer", it)
+			}
+			is[i] = n
+		}
+		if t.number == "1" {
+			return is[0], nil
+		}
+		return is, nil
+	}
+	if t.number == "1" {
+		return items[0], nil
+	}
+	return items, nil
+}
+
+// csqValue is a pullCSQ INFO value, or why it is left out
+type csqValue struct {
+	id  string
+	val interface{}
+	err error
+}
+
+// csqValues converts each field of the canonical and most severe entries,
+// as canonical_field and field, in the order they are written
+func csqValues(fields []string, types map[string]csqType, canon, severe map[string]string) []csqValue {
+	var out []csqValue
+	for _, f := range fields {
+		for _, pick := range []struct {
+			id  string
+			csq map[string]string
+		}{{"canonical_" + f, canon}, {f, severe}} {
+			val, err := types[f].value(pick.csq[f])
+			out = append(out, csqValue{pick.id, val, err})
+		}
+	}
+	return out
+}
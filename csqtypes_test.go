@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInferCSQType(t *testing.T) {
+	for field, want := range map[string]csqType{
+		"Consequence":         {"String", ".", false},
+		"DOMAINS":             {"String", ".", false},
+		"gnomAD_AF":           {"Float", "1", true},
+		"MAX_AF":              {"Float", "1", true},
+		"CADD_PHRED":          {"Float", "1", true},
+		"CADD_RAW":            {"Float", "1", true},
+		"REVEL_score":         {"Float", "1", true},
+		"REVEL_rankscore":     {"Float", "1", true},
+		"SpliceAI_pred_DS_AG": {"Float", "1", true},
+		"SpliceAI_pred_DP_AG": {"Integer", "1", false},
+		"DISTANCE":            {"Integer", "1", false},
+		"STRAND":              {"Integer", "1", false},
+		"SYMBOL":              {"String", "1", false},
+		"AFFECTED":            {"String", "1", false},
+	} {
+		if got := inferCSQType(field); got != want {
+			t.Errorf("%s: got %+v, want %+v", field, got, want)
+		}
+	}
+}
+
+func TestParseCSQTypes(t *testing.T) {
+	types, err := parseCSQTypes("REVEL_score:Float,DOMAINS:String:.,DISTANCE:Integer:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]csqType{
+		"REVEL_score": {typ: "Float", number: "1"},
+		"DOMAINS":     {typ: "String", number: "."},
+		"DISTANCE":    {typ: "Integer", number: "1"},
+	}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("got %+v, want %+v", types, want)
+	}
+	for _, s := range []string{"REVEL_score", "REVEL_score:Double", "DOMAINS:String:A", "a:Float:1:x"} {
+		if _, err := parseCSQTypes(s); err == nil {
+			t.Errorf("%q accepted", s)
+		}
+	}
+}
+
+func TestCSQTypeValue(t *testing.T) {
+	score := inferCSQType("REVEL_score")
+	for _, tc := range []struct {
+		name string
+		t    csqType
+		in   string
+		want interface{}
+		err  string
+	}{
+		{"score", score, "0.5", 0.5, ""},
+		{"scores reduced to their max", score, "0.5&0.9&0.1", 0.9, ""},
+		{"missing items skipped", score, ".&0.3&", 0.3, ""},
+		{"empty", score, "", nil, ""},
+		{"only missing", score, ".&.", nil, ""},
+		{"score that does not convert", score, "0.5&high", nil, `"high" is not a Float`},
+		{"-types Float without max", csqType{typ: "Float", number: "1"}, "0.5&0.9", nil, "give the field Number ."},
+		{"Float list", csqType{typ: "Float", number: "."}, "0.5&0.9", []float64{0.5, 0.9}, ""},
+		{"Integer", inferCSQType("DISTANCE"), "12", 12, ""},
+		{"Integer that does not convert", inferCSQType("DISTANCE"), "1.5", nil, `"1.5" is not an Integer`},
+		{"Integer with several values", inferCSQType("DISTANCE"), "1&2", nil, "give the field Number ."},
+		{"Integer list", csqType{typ: "Integer", number: "."}, "1&2", []int{1, 2}, ""},
+		{"String keeps several values as written", inferCSQType("SYMBOL"), "A&B", "A&B", ""},
+		{"String list", inferCSQType("Consequence"), "missense_variant&splice_region_variant", []string{"missense_variant", "splice_region_variant"}, ""},
+	} {
+		got, err := tc.t.value(tc.in)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: got %v, want %q", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %#v, want %#v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCSQValues(t *testing.T) {
+	fields := []string{"REVEL_score", "SYMBOL"}
+	types := map[string]csqType{"REVEL_score": inferCSQType("REVEL_score"), "SYMBOL": inferCSQType("SYMBOL")}
+	canon := map[string]string{"REVEL_score": "0.2&0.4", "SYMBOL": "GENE1"}
+	severe := map[string]string{"REVEL_score": "0.7&n/a", "SYMBOL": ""}
+
+	var got []string
+	for _, cv := range csqValues(fields, types, canon, severe) {
+		switch {
+		case cv.err != nil:
+			got = append(got, cv.id+" omitted")
+		case cv.val == nil:
+			got = append(got, cv.id+" missing")
+		default:
+			got = append(got, fmt.Sprintf("%s=%v", cv.id, cv.val))
+		}
+	}
+	want := "canonical_REVEL_score=0.4,REVEL_score omitted,canonical_SYMBOL=GENE1,SYMBOL missing"
+	if strings.Join(got, ",") != want {
+		t.Errorf("got %s, want %s", strings.Join(got, ","), want)
+	}
+}
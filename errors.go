@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/brentp/vcfgo"
+	"github.com/google/subcommands"
+)
+
+// errPolicy reports a command's errors on stderr, prefixed with the command
+// name. Errors in a single record follow -on-error: skip drops the record,
+// warn drops it with a message and fail stops the run.
+type errPolicy struct {
+	cmd  string
+	mode string
+	st   *runStats
+}
+
+// errPolicyFrom returns the errPolicy wrap passes to Execute, or one that
+// fails on any error when the command is run some other way
+func errPolicyFrom(args []interface{}) *errPolicy {
+	for _, a := range args {
+		if ep, ok := a.(*errPolicy); ok {
+			return ep
+		}
+	}
+	return &errPolicy{cmd: "vcfUtils", mode: "fail", st: statsFrom(args)}
+}
+
+func (e *errPolicy) fatal(err error) subcommands.ExitStatus {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", e.cmd, err)
+	return subcommands.ExitFailure
+}
+
+func (e *errPolicy) usage(msg string) subcommands.ExitStatus {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", e.cmd, msg)
+	return subcommands.ExitUsageError
+}
+
+// record handles an error in one record, where says which. It returns false
+// when the command should stop with ExitFailure.
+func (e *errPolicy) record(where string, err error) bool {
+	e.st.count("errors")
+	if e.mode != "skip" {
+		fmt.Fprintf(os.Stderr, "%s: %s: %s\n", e.cmd, where, err)
+	}
+	return e.mode != "fail"
+}
+
+// readErr returns and clears the errors vcfgo collected parsing the last
+// record read
+func readErr(rdr *vcfgo.Reader) error {
+	err := rdr.Error()
+	rdr.Clear()
+	return err
+}
+
+func variantWhere(v *vcfgo.Variant) string {
+	return fmt.Sprintf("%s:%d %s>%s", v.Chromosome, v.Pos, v.Reference, strings.Join(v.Alternate, ","))
+}
+
+func lineWhere(path string, n int) string {
+	return fmt.Sprintf("%s line %d", path, n)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/vcfgo"
+)
+
+// criterion is one check a rank tier made, e.g. isRare with the
+// frequencies it compared
+type criterion struct {
+	Name   string `json:"name"`
+	Met    bool   `json:"met"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type tierTrace struct {
+	Tier     string      `json:"tier"`
+	Matched  bool        `json:"matched"`
+	Criteria []criterion `json:"criteria"`
+}
+
+// explanation records the tiers rank evaluated for a variant. Its methods
+// are safe on a nil explanation so the tiers can always call them.
+type explanation struct {
+	Variant     string       `json:"variant"`
+	Rank        float64      `json:"rank,omitempty"`
+	CompHetRank float64      `json:"comphet_rank,omitempty"`
+	Tiers       []*tierTrace `json:"tiers"`
+}
+
+func (e *explanation) begin(tier string) {
+	if e != nil {
+		e.Tiers = append(e.Tiers, &tierTrace{Tier: tier})
+	}
+}
+
+func (e *explanation) end(matched bool) bool {
+	if e != nil && len(e.Tiers) > 0 {
+		e.Tiers[len(e.Tiers)-1].Matched = matched
+	}
+	return matched
+}
+
+// note adds a criterion to the current tier and returns met
+func (e *explanation) note(name string, met bool, detail string) bool {
+	if e != nil && len(e.Tiers) > 0 {
+		t := e.Tiers[len(e.Tiers)-1]
+		t.Criteria = append(t.Criteria, criterion{Name: name, Met: met, Detail: detail})
+	}
+	return met
+}
+
+// explainEscaper percent-encodes the characters VCF 4.3 reserves in INFO
+// values, so the tier values keep their = without breaking the INFO column
+var explainEscaper = strings.NewReplacer("%", "%25", "=", "%3D", ";", "%3B", ",", "%2C", " ", "_", "\t", "%09", "\n", "%0A", "\r", "%0D")
+
+// info renders one value per tier for a Number=. INFO field, e.g.
+// tier2%3Dfalse:isLGD%3Dtrue|isRare%3Dfalse(eAF_popmax%3D0.0003>0.0001)
+// which decodes to tier2=false:isLGD=true|isRare=false(eAF_popmax=0.0003>0.0001)
+func (e *explanation) info() string {
+	var tiers []string
+	for _, t := range e.Tiers {
+		var cs []string
+		for _, c := range t.Criteria {
+			s := c.Name + "=" + strconv.FormatBool(c.Met)
+			if c.Detail != "" {
+				s += "(" + c.Detail + ")"
+			}
+			cs = append(cs, s)
+		}
+		tiers = append(tiers, explainEscaper.Replace(fmt.Sprintf("%s=%t:%s", t.Tier, t.Matched, strings.Join(cs, "|"))))
+	}
+	return strings.Join(tiers, ",")
+}
+
+func fmtNum(x float64) string {
+	return strconv.FormatFloat(x, 'g', -1, 64)
+}
+
+// shown is an INFO value as written, or . when missing
+func shown(v *vcfgo.Variant, key string) string {
+	if s := infoFirst(v, key); s != "" {
+		return s
+	}
+	return "."
+}
+
+// cmpDetail shows the value a tier used against its cutoff,
+// e.g. eAF_popmax=0.0003>0.0001
+func cmpDetail(key, val string, x, cut float64) string {
+	op := "="
+	switch {
+	case x < cut:
+		op = "<"
+	case x > cut:
+		op = ">"
+	}
+	return key + "=" + val + op + fmtNum(cut)
+}
+
+// gnomADDetail names the field getGnomAD read and its value
+func gnomADDetail(v *vcfgo.Variant) (string, string) {
+	for _, k := range []string{"eAF_popmax", "gAF_popmax"} {
+		if s := infoFirst(v, k); s != "" {
+			return k, s
+		}
+	}
+	return "eAF_popmax", "."
+}
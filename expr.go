@@ -0,0 +1,732 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/vcfgo"
+)
+
+// Expressions are evaluated per variant, e.g.
+//
+//	INFO.CADD_phred >= 25 && sample("proband").GT == "het"
+//
+// Values are numbers, strings, bools, lists or missing. CHROM, POS, ID, REF,
+// ALT, QUAL and FILTER read the fixed columns, INFO.key and FORMAT.key (a
+// list over samples) read fields, and sample("name").key reads one sample.
+// GT reads as hom_ref, het, hom_alt or missing. Comparing a list is true if
+// any element matches, comparing a missing value is always false.
+
+type exprNode interface {
+	eval(v *vcfgo.Variant) interface{}
+}
+
+type infoNS struct{}
+type formatNS struct{}
+type sampleRef int
+
+type litNode struct{ val interface{} }
+type identNode struct{ name string }
+type selectorNode struct {
+	x    exprNode
+	name string
+}
+type indexNode struct{ x, i exprNode }
+type callNode struct {
+	name string
+	args []exprNode
+}
+type unaryNode struct {
+	op string
+	x  exprNode
+}
+type binaryNode struct {
+	op   string
+	l, r exprNode
+	re   *regexp.Regexp
+}
+
+// filterExpr is a compiled expression
+type filterExpr struct {
+	src  string
+	root exprNode
+}
+
+func (e *filterExpr) eval(v *vcfgo.Variant) interface{} { return e.root.eval(v) }
+
+func (e *filterExpr) match(v *vcfgo.Variant) bool { return truthy(e.root.eval(v)) }
+
+func (n litNode) eval(*vcfgo.Variant) interface{} { return n.val }
+
+func (n identNode) eval(v *vcfgo.Variant) interface{} {
+	switch n.name {
+	case "CHROM":
+		return v.Chromosome
+	case "POS":
+		return float64(v.Pos)
+	case "ID":
+		return missingDot(v.Id_)
+	case "REF":
+		return v.Reference
+	case "ALT":
+		alts := make([]interface{}, len(v.Alternate))
+		for i, a := range v.Alternate {
+			alts[i] = a
+		}
+		return alts
+	case "QUAL":
+		return float64(v.Quality)
+	case "FILTER":
+		return missingDot(v.Filter)
+	case "INFO":
+		return infoNS{}
+	case "FORMAT":
+		return formatNS{}
+	}
+	return nil
+}
+
+func missingDot(s string) interface{} {
+	if s == "" || s == "." {
+		return nil
+	}
+	return s
+}
+
+// exprValue converts typed INFO values into expression values
+func exprValue(val interface{}) interface{} {
+	switch val := val.(type) {
+	case nil:
+		return nil
+	case bool, float64, string:
+		return val
+	case int:
+		return float64(val)
+	case float32:
+		return float64(val)
+	}
+	var list []interface{}
+	for _, s := range infoStrings(val) {
+		list = append(list, rawValue(s))
+	}
+	return list
+}
+
+// rawValue converts a text field, splitting comma lists and reading numbers
+func rawValue(s string) interface{} {
+	if strings.Contains(s, ",") {
+		var list []interface{}
+		for _, p := range strings.Split(s, ",") {
+			list = append(list, rawValue(p))
+		}
+		return list
+	}
+	if s == "" || s == "." {
+		return nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
+
+// gtClass classifies a raw GT such as 0/1 or 1|1
+func gtClass(gt string) string {
+	alleles := strings.FieldsFunc(gt, func(r rune) bool { return r == '/' || r == '|' })
+	if len(alleles) == 0 {
+		return "missing"
+	}
+	for _, a := range alleles {
+		if a == "." {
+			return "missing"
+		}
+	}
+	for _, a := range alleles[1:] {
+		if a != alleles[0] {
+			return "het"
+		}
+	}
+	if alleles[0] == "0" {
+		return "hom_ref"
+	}
+	return "hom_alt"
+}
+
+func sampleValue(v *vcfgo.Variant, i int, key string) interface{} {
+	if i >= len(v.Samples) || v.Samples[i] == nil {
+		return nil
+	}
+	raw, ok := sampleField(v.Samples[i], key)
+	if !ok {
+		return nil
+	}
+	if key == "GT" {
+		return gtClass(raw)
+	}
+	return rawValue(raw)
+}
+
+func (n selectorNode) eval(v *vcfgo.Variant) interface{} {
+	switch x := n.x.eval(v).(type) {
+	case infoNS:
+		val, err := v.Info().Get(n.name)
+		if err != nil {
+			return nil
+		}
+		return exprValue(val)
+	case formatNS:
+		list := make([]interface{}, len(v.Samples))
+		for i := range v.Samples {
+			list[i] = sampleValue(v, i, n.name)
+		}
+		return list
+	case sampleRef:
+		return sampleValue(v, int(x), n.name)
+	}
+	return nil
+}
+
+func (n indexNode) eval(v *vcfgo.Variant) interface{} {
+	x := n.x.eval(v)
+	i := n.i.eval(v)
+	if key, ok := i.(string); ok {
+		return selectorNode{x: litNode{x}, name: key}.eval(v)
+	}
+	list, ok := x.([]interface{})
+	f, isNum := i.(float64)
+	if !ok || !isNum || int(f) < 0 || int(f) >= len(list) {
+		return nil
+	}
+	return list[int(f)]
+}
+
+func (n callNode) eval(v *vcfgo.Variant) interface{} {
+	switch n.name {
+	case "sample":
+		return n.args[0].eval(v)
+	case "exists":
+		x := n.args[0].eval(v)
+		if list, ok := x.([]interface{}); ok {
+			for _, e := range list {
+				if e != nil {
+					return true
+				}
+			}
+			return false
+		}
+		return x != nil
+	case "len":
+		switch x := n.args[0].eval(v).(type) {
+		case []interface{}:
+			return float64(len(x))
+		case string:
+			return float64(len(x))
+		case nil:
+			return float64(0)
+		}
+		return float64(1)
+	case "contains":
+		x := n.args[0].eval(v)
+		y := n.args[1].eval(v)
+		if list, ok := x.([]interface{}); ok {
+			for _, e := range list {
+				if compare("==", e, y) {
+					return true
+				}
+			}
+			return false
+		}
+		xs, ok1 := x.(string)
+		ys, ok2 := y.(string)
+		return ok1 && ok2 && strings.Contains(xs, ys)
+	case "abs":
+		if f, ok := toNumber(n.args[0].eval(v)); ok {
+			return math.Abs(f)
+		}
+	}
+	return nil
+}
+
+func (n unaryNode) eval(v *vcfgo.Variant) interface{} {
+	x := n.x.eval(v)
+	if n.op == "!" {
+		return !truthy(x)
+	}
+	if f, ok := toNumber(x); ok {
+		return -f
+	}
+	return nil
+}
+
+func (n binaryNode) eval(v *vcfgo.Variant) interface{} {
+	switch n.op {
+	case "&&":
+		return truthy(n.l.eval(v)) && truthy(n.r.eval(v))
+	case "||":
+		return truthy(n.l.eval(v)) || truthy(n.r.eval(v))
+	case "=~", "!~":
+		m := anyValue(n.l.eval(v), func(x interface{}) bool {
+			s, ok := x.(string)
+			return ok && n.re.MatchString(s)
+		})
+		if n.op == "!~" {
+			return !m
+		}
+		return m
+	case "+", "-", "*", "/":
+		l, ok1 := toNumber(n.l.eval(v))
+		r, ok2 := toNumber(n.r.eval(v))
+		if !ok1 || !ok2 {
+			return nil
+		}
+		switch n.op {
+		case "+":
+			return l + r
+		case "-":
+			return l - r
+		case "*":
+			return l * r
+		}
+		return l / r
+	}
+	r := n.r.eval(v)
+	return anyValue(n.l.eval(v), func(x interface{}) bool { return compare(n.op, x, r) })
+}
+
+// anyValue applies f to x, or to each element when x is a list
+func anyValue(x interface{}, f func(interface{}) bool) bool {
+	if list, ok := x.([]interface{}); ok {
+		for _, e := range list {
+			if f(e) {
+				return true
+			}
+		}
+		return false
+	}
+	return f(x)
+}
+
+func toNumber(x interface{}) (float64, bool) {
+	switch x := x.(type) {
+	case float64:
+		return x, true
+	case bool:
+		if x {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(x, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func compare(op string, l, r interface{}) bool {
+	if l == nil || r == nil {
+		return false
+	}
+	if rl, ok := r.([]interface{}); ok {
+		return anyValue(rl, func(e interface{}) bool { return compare(op, l, e) })
+	}
+
+	var c int
+	lf, ok1 := toNumber(l)
+	rf, ok2 := toNumber(r)
+	_, lstr := l.(string)
+	_, rstr := r.(string)
+	switch {
+	case ok1 && ok2 && !(lstr && rstr):
+		switch {
+		case lf < rf:
+			c = -1
+		case lf > rf:
+			c = 1
+		}
+	default:
+		c = strings.Compare(fmt.Sprint(l), fmt.Sprint(r))
+	}
+
+	switch op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+func truthy(x interface{}) bool {
+	switch x := x.(type) {
+	case nil:
+		return false
+	case bool:
+		return x
+	case float64:
+		return x != 0
+	case string:
+		return x != ""
+	case []interface{}:
+		for _, e := range x {
+			if truthy(e) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+type token struct {
+	kind string // num, str, ident, op, eof
+	text string
+	pos  int
+}
+
+func lexExpr(src string) ([]token, error) {
+	var toks []token
+	ops := []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "(", ")", "[", "]", ".", ",", "+", "-", "*", "/"}
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.' ||
+				src[j] == 'e' || src[j] == 'E' || (src[j] == '-' || src[j] == '+') && (src[j-1] == 'e' || src[j-1] == 'E')) {
+				j++
+			}
+			toks = append(toks, token{"num", src[i:j], i})
+			i = j
+		case c == '"' || c == '\'':
+			j := i + 1
+			var sb strings.Builder
+			for j < len(src) && src[j] != c {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+				}
+				sb.WriteByte(src[j])
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			toks = append(toks, token{"str", sb.String(), i})
+			i = j + 1
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] >= 'a' && src[j] <= 'z' || src[j] >= 'A' && src[j] <= 'Z' || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			toks = append(toks, token{"ident", src[i:j], i})
+			i = j
+		default:
+			found := false
+			for _, op := range ops {
+				if strings.HasPrefix(src[i:], op) {
+					toks = append(toks, token{"op", op, i})
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+		}
+	}
+	return append(toks, token{"eof", "", len(src)}), nil
+}
+
+type exprParser struct {
+	toks    []token
+	pos     int
+	samples map[string]int
+}
+
+func (p *exprParser) peek() token { return p.toks[p.pos] }
+
+func (p *exprParser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != "eof" {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != "op" {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) expect(op string) error {
+	if _, ok := p.accept(op); !ok {
+		t := p.peek()
+		return fmt.Errorf("expected %q at %d, found %q", op, t.pos, t.text)
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("||"); !ok {
+			return l, nil
+		}
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryNode{op: "||", l: l, r: r}
+	}
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("&&"); !ok {
+			return l, nil
+		}
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryNode{op: "&&", l: l, r: r}
+	}
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if _, ok := p.accept("!"); ok {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{op: "!", x: x}, nil
+	}
+	return p.parseCmp()
+}
+
+func (p *exprParser) parseCmp() (exprNode, error) {
+	l, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("==", "!=", "<=", ">=", "<", ">", "=~", "!~")
+	if !ok {
+		return l, nil
+	}
+	r, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	n := binaryNode{op: op, l: l, r: r}
+	if op == "=~" || op == "!~" {
+		lit, ok := r.(litNode)
+		s, isStr := lit.val.(string)
+		if !ok || !isStr {
+			return nil, fmt.Errorf("%s needs a string regular expression", op)
+		}
+		if n.re, err = regexp.Compile(s); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+func (p *exprParser) parseAdd() (exprNode, error) {
+	l, err := p.parseMul()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("+", "-")
+		if !ok {
+			return l, nil
+		}
+		r, err := p.parseMul()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryNode{op: op, l: l, r: r}
+	}
+}
+
+func (p *exprParser) parseMul() (exprNode, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("*", "/")
+		if !ok {
+			return l, nil
+		}
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryNode{op: op, l: l, r: r}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if _, ok := p.accept("-"); ok {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{op: "-", x: x}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *exprParser) parsePostfix() (exprNode, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("."); ok {
+			t := p.next()
+			if t.kind != "ident" {
+				return nil, fmt.Errorf("expected field name at %d", t.pos)
+			}
+			x = selectorNode{x: x, name: t.text}
+			continue
+		}
+		if _, ok := p.accept("["); ok {
+			i, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = indexNode{x: x, i: i}
+			continue
+		}
+		return x, nil
+	}
+}
+
+var exprFuncs = map[string]int{"sample": 1, "exists": 1, "len": 1, "contains": 2, "abs": 1}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case "num":
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q at %d", t.text, t.pos)
+		}
+		return litNode{f}, nil
+	case "str":
+		return litNode{t.text}, nil
+	case "ident":
+		switch t.text {
+		case "true":
+			return litNode{true}, nil
+		case "false":
+			return litNode{false}, nil
+		}
+		if _, ok := p.accept("("); !ok {
+			switch t.text {
+			case "CHROM", "POS", "ID", "REF", "ALT", "QUAL", "FILTER", "INFO", "FORMAT":
+				return identNode{t.text}, nil
+			}
+			return nil, fmt.Errorf("unknown name %q at %d, INFO fields are written INFO.%s", t.text, t.pos, t.text)
+		}
+		nargs, ok := exprFuncs[t.text]
+		if !ok {
+			return nil, fmt.Errorf("unknown function %q at %d", t.text, t.pos)
+		}
+		var args []exprNode
+		for len(args) < nargs {
+			if len(args) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			a, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, a)
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		if t.text == "sample" {
+			lit, ok := args[0].(litNode)
+			name, isStr := lit.val.(string)
+			if !ok || !isStr {
+				return nil, fmt.Errorf("sample() takes a quoted sample name at %d", t.pos)
+			}
+			i, ok := p.samples[name]
+			if !ok {
+				return nil, fmt.Errorf("sample %q not in vcf", name)
+			}
+			args[0] = litNode{sampleRef(i)}
+		}
+		return callNode{name: t.text, args: args}, nil
+	case "op":
+		if t.text == "(" {
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		}
+	case "eof":
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+// compileExpr parses src, resolving sample names against the header
+func compileExpr(src string, h *vcfgo.Header) (*filterExpr, error) {
+	toks, err := lexExpr(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", src, err)
+	}
+	p := &exprParser{toks: toks, samples: map[string]int{}}
+	for i, s := range h.SampleNames {
+		p.samples[s] = i
+	}
+	root, err := p.parseOr()
+	if err == nil && p.peek().kind != "eof" {
+		err = fmt.Errorf("unexpected %q at %d", p.peek().text, p.peek().pos)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", src, err)
+	}
+	return &filterExpr{src: src, root: root}, nil
+}
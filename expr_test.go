@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/brentp/vcfgo"
+)
+
+// testInfo is an INFO column already split into typed values
+type testInfo map[string]interface{}
+
+func (i testInfo) Get(key string) (interface{}, error) {
+	if val, ok := i[key]; ok {
+		return val, nil
+	}
+	return nil, fmt.Errorf("%s not found", key)
+}
+func (i testInfo) Set(key string, value interface{}) error { i[key] = value; return nil }
+func (i testInfo) Delete(key string)                       { delete(i, key) }
+func (i testInfo) Keys() []string                          { return nil }
+func (i testInfo) String() string                          { return "" }
+func (i testInfo) Bytes() []byte                           { return nil }
+
+func exprVariant() (*vcfgo.Variant, *vcfgo.Header) {
+	h := &vcfgo.Header{SampleNames: []string{"proband", "mother"}}
+	v := &vcfgo.Variant{
+		Chromosome: "chr1", Pos: 100, Id_: ".", Reference: "A", Alternate: []string{"G", "T"},
+		Quality: 50, Filter: "PASS",
+		Info_: testInfo{"CADD_phred": 30.0, "AF": []float64{0.1, 0.5}, "gene": "BRCA1", "code": "07"},
+		Samples: []*vcfgo.SampleGenotype{
+			{Fields: map[string]string{"GT": "0/1", "DP": "30"}},
+			{Fields: map[string]string{"GT": "0/0"}},
+		},
+		Header: h,
+	}
+	return v, h
+}
+
+func TestExprMatch(t *testing.T) {
+	v, h := exprVariant()
+	for _, tc := range []struct {
+		expr string
+		want bool
+	}{
+		// precedence
+		{"1 + 2 * 3 == 7", true},
+		{"(1 + 2) * 3 == 9", true},
+		{"10 - 4 - 3 == 3", true},
+		{"8 / 4 / 2 == 1", true},
+		{"-2 * 3 == -6", true},
+		{"true || false && false", true},
+		{"(true || false) && false", false},
+		{"!false && false", false},
+		{"!INFO.CADD_phred > 40", true},
+		{"INFO.CADD_phred >= 25 && sample(\"proband\").GT == \"het\"", true},
+
+		// missing values compare false either way
+		{"INFO.missing > 1", false},
+		{"INFO.missing <= 1", false},
+		{"INFO.missing == INFO.missing", false},
+		{"!(INFO.missing > 1)", true},
+		{"INFO.missing + 1 > 0", false},
+		{"exists(INFO.missing)", false},
+		{"len(INFO.missing) == 0", true},
+		{"ID == \".\"", false},
+		{"sample(\"mother\").DP > 0", false},
+		{"sample(\"mother\").DP < 1", false},
+		{"FORMAT.DP > 20", true},
+		{"ALT[5] == \"G\"", false},
+
+		// strings against numbers
+		{"\"10\" < \"9\"", true},
+		{"10 < 9", false},
+		{"\"10\" < 9", false},
+		{"INFO.code == 7", true},
+		{"INFO.code == \"7\"", false},
+		{"INFO.gene > 5", true},
+		{"INFO.gene == \"BRCA1\"", true},
+		{"INFO.gene =~ \"^BRCA\"", true},
+		{"INFO.gene !~ \"^BRCA\"", false},
+		{"CHROM == \"chr1\" && POS == 100 && QUAL >= 50", true},
+
+		// lists match when any element does
+		{"ALT == \"T\"", true},
+		{"ALT[0] == \"G\"", true},
+		{"INFO.AF > 0.4", true},
+		{"INFO.AF < 0.05", false},
+		{"contains(ALT, \"T\")", true},
+		{"len(ALT) == 2", true},
+		{"FORMAT.GT == \"hom_ref\"", true},
+	} {
+		e, err := compileExpr(tc.expr, h)
+		if err != nil {
+			t.Errorf("%s: %s", tc.expr, err)
+			continue
+		}
+		if got := e.match(v); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestExprParseErrors(t *testing.T) {
+	_, h := exprVariant()
+	for _, tc := range []struct {
+		expr, want string
+	}{
+		{"INFO.AF >", "unexpected end of expression"},
+		{"CADD_phred > 1", `unknown name "CADD_phred" at 0, INFO fields are written INFO.CADD_phred`},
+		{"nope(1)", `unknown function "nope"`},
+		{"sample(\"nobody\").GT == \"het\"", `sample "nobody" not in vcf`},
+		{"sample(1).GT", "sample() takes a quoted sample name"},
+		{"INFO.gene == \"BRCA", "unterminated string at 13"},
+		{"1 2", `unexpected "2" at 2`},
+		{"1 < 2 < 3", `unexpected "<" at 6`},
+		{"INFO.AF # 1", `unexpected '#' at 8`},
+		{"(1 + 2", `expected ")" at 6`},
+		{"INFO.1", "expected field name at 5"},
+		{"INFO.gene =~ 5", "=~ needs a string regular expression"},
+		{"INFO.gene =~ \"(\"", "missing closing )"},
+		{"contains(ALT)", `expected "," at 12`},
+	} {
+		_, err := compileExpr(tc.expr, h)
+		if err == nil {
+			t.Errorf("%s: no error", tc.expr)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.expr, err, tc.want)
+		}
+	}
+}
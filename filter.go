@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/vcfgo"
+	"github.com/google/subcommands"
+)
+
+type filter struct {
+	include    string
+	exclude    string
+	softFilter string
+}
+
+func (*filter) Name() string { return "filter" }
+func (*filter) Synopsis() string {
+	return "keep or drop variants using an expression over CHROM/POS/QUAL/FILTER/INFO/FORMAT and samples"
+}
+func (*filter) Usage() string {
+	return `filter -include 'INFO.CADD_phred >= 25 && sample("proband").GT == "het"' [-soft-filter LowCADD]
+filter -exclude 'INFO.gAF_popmax > 0.01'
+`
+}
+
+func (fl *filter) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&fl.include, "include", "", "keep variants where the expression is true")
+	f.StringVar(&fl.exclude, "exclude", "", "drop variants where the expression is true")
+	f.StringVar(&fl.softFilter, "soft-filter", "", "instead of dropping failing variants, add this name to their FILTER column")
+}
+
+func (fl *filter) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := statsFrom(args)
+	ep := errPolicyFrom(args)
+	if (fl.include == "") == (fl.exclude == "") {
+		return ep.usage("give one of -include or -exclude")
+	}
+
+	rdr, err := vcfgo.NewReader(os.Stdin, false)
+	if err != nil {
+		return ep.fatal(err)
+	}
+
+	src := fl.include
+	if fl.exclude != "" {
+		src = fl.exclude
+	}
+	expr, err := compileExpr(src, rdr.Header)
+	if err != nil {
+		return ep.fatal(err)
+	}
+
+	if fl.softFilter != "" {
+		if fl.include != "" {
+			rdr.Header.Filters[fl.softFilter] = "failed vcfUtils filter -include " + strconv.Quote(src)
+		} else {
+			rdr.Header.Filters[fl.softFilter] = "vcfUtils filter -exclude " + strconv.Quote(src)
+		}
+	}
+
+	addProvenance(rdr.Header, ep.cmd)
+	wrt, err := vcfgo.NewWriter(os.Stdout, rdr.Header)
+	if err != nil {
+		return ep.fatal(err)
+	}
+
+	for {
+		variant := rdr.Read()
+		if variant == nil {
+			break
+		}
+		st.RecordsIn++
+		if err := readErr(rdr); err != nil {
+			if !ep.record(variantWhere(variant), err) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+
+		pass := expr.match(variant)
+		if fl.exclude != "" {
+			pass = !pass
+		}
+
+		switch {
+		case pass:
+			st.RecordsOut++
+			wrt.WriteVariant(variant)
+		case fl.softFilter != "":
+			addFilter(variant, fl.softFilter)
+			st.count("soft_filtered")
+			st.RecordsOut++
+			wrt.WriteVariant(variant)
+		default:
+			st.count("dropped")
+		}
+	}
+	return subcommands.ExitSuccess
+}
+
+func addFilter(v *vcfgo.Variant, name string) {
+	switch v.Filter {
+	case "", ".", "PASS":
+		v.Filter = name
+	default:
+		for _, f := range strings.Split(v.Filter, ";") {
+			if f == name {
+				return
+			}
+		}
+		v.Filter += ";" + name
+	}
+}
+
+type rankRule struct {
+	rank float64
+	expr *filterExpr
+}
+
+// readRankRules reads tab separated rank and expression lines, e.g.
+//
+//	1.5	INFO.vep_SYMBOL == "SCN2A" && INFO.vep_IMPACT == "HIGH"
+func readRankRules(path string, h *vcfgo.Header) ([]rankRule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rules []rankRule
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rs, src, ok := strings.Cut(line, "\t")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected rank<TAB>expression", path, n)
+		}
+		r, err := strconv.ParseFloat(rs, 64)
+		if err != nil || r == 0 {
+			return nil, fmt.Errorf("%s:%d: bad rank %q", path, n, rs)
+		}
+		expr, err := compileExpr(strings.TrimSpace(src), h)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, n, err)
+		}
+		rules = append(rules, rankRule{rank: r, expr: expr})
+	}
+	return rules, scanner.Err()
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/vcfgo"
+	"github.com/google/subcommands"
+)
+
+// isNonRef is true for the gVCF symbolic alleles standing for any other allele
+func isNonRef(alt string) bool {
+	return alt == "<NON_REF>" || alt == "<*>"
+}
+
+// isRefBlock is true for gVCF reference block records, those whose only
+// alt alleles are <NON_REF> or <*>
+func isRefBlock(v *vcfgo.Variant) bool {
+	if len(v.Alternate) == 0 {
+		return false
+	}
+	for _, a := range v.Alternate {
+		if !isNonRef(a) {
+			return false
+		}
+	}
+	return true
+}
+
+// isGVCF looks for the header lines GATK and bcftools write in gVCFs
+func isGVCF(h *vcfgo.Header) bool {
+	for _, e := range h.Extras {
+		if strings.HasPrefix(e, "##GVCFBlock") || strings.HasPrefix(e, "##ALT=<ID=NON_REF") || strings.HasPrefix(e, "##ALT=<ID=*") {
+			return true
+		}
+	}
+	return false
+}
+
+// gvcfBlocks is what a command does with gVCF reference blocks: pass writes
+// them unchanged without annotating them, drop leaves them out
+type gvcfBlocks struct {
+	mode string
+}
+
+func (g *gvcfBlocks) setFlags(f *flag.FlagSet) {
+	f.StringVar(&g.mode, "gvcf", "pass", "gVCF reference blocks (ALT only <NON_REF> or <*>): pass writes them unchanged, drop leaves them out")
+}
+
+// begin checks -gvcf and notes on stderr when the input is a gVCF
+func (g *gvcfBlocks) begin(h *vcfgo.Header, cmd string) error {
+	switch g.mode {
+	case "pass", "drop":
+	default:
+		return fmt.Errorf("-gvcf must be pass or drop")
+	}
+	if isGVCF(h) {
+		fmt.Fprintf(os.Stderr, "%s: input is a gVCF, reference blocks are %s\n", cmd, map[string]string{"pass": "passed through", "drop": "dropped"}[g.mode])
+	}
+	return nil
+}
+
+// skip handles v if it is a reference block and reports whether it was one
+func (g *gvcfBlocks) skip(v *vcfgo.Variant, wrt *vcfgo.Writer, st *runStats) bool {
+	if !isRefBlock(v) {
+		return false
+	}
+	st.count("ref_blocks")
+	if g.mode == "pass" {
+		st.RecordsOut++
+		wrt.WriteVariant(v)
+	}
+	return true
+}
+
+// dropAllele removes allele a (0 is REF) from a Number=A, R or G list of
+// values; n is the number of alleles before removal
+func dropAllele(vals []string, number string, a, n int) []string {
+	var out []string
+	switch number {
+	case "A":
+		for i, v := range vals {
+			if i != a-1 {
+				out = append(out, v)
+			}
+		}
+	case "R":
+		for i, v := range vals {
+			if i != a {
+				out = append(out, v)
+			}
+		}
+	case "G":
+		if len(vals) == n {
+			// haploid
+			return dropAllele(vals, "R", a, n)
+		}
+		// diploid genotypes j/k are ordered by k then j
+		i := 0
+		for k := 0; k < n; k++ {
+			for j := 0; j <= k; j++ {
+				if i < len(vals) && j != a && k != a {
+					out = append(out, vals[i])
+				}
+				i++
+			}
+		}
+	default:
+		return vals
+	}
+	return out
+}
+
+// dropGT removes allele a from a GT string, calls of it becoming missing
+func dropGT(gt string, a int) string {
+	var b strings.Builder
+	start := 0
+	for i := 0; i <= len(gt); i++ {
+		if i < len(gt) && gt[i] != '/' && gt[i] != '|' {
+			continue
+		}
+		al := gt[start:i]
+		if n, err := strconv.Atoi(al); err == nil {
+			switch {
+			case n == a:
+				al = "."
+			case n > a:
+				al = strconv.Itoa(n - 1)
+			}
+		}
+		b.WriteString(al)
+		if i < len(gt) {
+			b.WriteByte(gt[i])
+		}
+		start = i + 1
+	}
+	return b.String()
+}
+
+type gvcf2vcf struct{}
+
+func (*gvcf2vcf) Name() string { return "gvcf2vcf" }
+func (*gvcf2vcf) Synopsis() string {
+	return "drop gVCF reference blocks and <NON_REF> alleles"
+}
+func (*gvcf2vcf) Usage() string {
+	return `gvcf2vcf < in.g.vcf > out.vcf
+
+Drops reference blocks, records whose only alts are <NON_REF> or <*>, and
+removes those alleles from the remaining records along with their values
+in Number=A, R and G INFO and FORMAT fields.
+`
+}
+
+func (*gvcf2vcf) SetFlags(f *flag.FlagSet) {}
+
+func (*gvcf2vcf) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := statsFrom(args)
+	ep := errPolicyFrom(args)
+	rdr, err := vcfgo.NewReader(os.Stdin, false)
+	if err != nil {
+		return ep.fatal(err)
+	}
+	if !isGVCF(rdr.Header) {
+		fmt.Fprintf(os.Stderr, "%s: no gVCF header lines, converting anyway\n", ep.cmd)
+	}
+
+	var extras []string
+	for _, e := range rdr.Header.Extras {
+		if !strings.HasPrefix(e, "##GVCFBlock") && !strings.HasPrefix(e, "##ALT=<ID=NON_REF") && !strings.HasPrefix(e, "##ALT=<ID=*") {
+			extras = append(extras, e)
+		}
+	}
+	rdr.Header.Extras = extras
+
+	addProvenance(rdr.Header, ep.cmd)
+	wrt, err := vcfgo.NewWriter(os.Stdout, rdr.Header)
+	if err != nil {
+		return ep.fatal(err)
+	}
+
+	for {
+		variant := rdr.Read()
+		if variant == nil {
+			break
+		}
+		st.RecordsIn++
+		if err := readErr(rdr); err != nil {
+			if !ep.record(variantWhere(variant), err) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+		if isRefBlock(variant) {
+			st.count("ref_blocks")
+			continue
+		}
+
+		// remove from the last so earlier allele numbers stay put
+		for ai := len(variant.Alternate) - 1; ai >= 0; ai-- {
+			if !isNonRef(variant.Alternate[ai]) {
+				continue
+			}
+			a, n := ai+1, len(variant.Alternate)+1
+			st.count("non_ref_removed")
+
+			for _, k := range variant.Info().Keys() {
+				info, ok := rdr.Header.Infos[k]
+				if !ok || info.Number != "A" && info.Number != "R" && info.Number != "G" {
+					continue
+				}
+				val, err := variant.Info().Get(k)
+				if err != nil {
+					continue
+				}
+				if vals := dropAllele(infoStrings(val), info.Number, a, n); len(vals) > 0 {
+					_ = variant.Info().Set(k, strings.Join(vals, ","))
+				} else {
+					variant.Info().Delete(k)
+				}
+			}
+			for _, s := range variant.Samples {
+				if s == nil {
+					continue
+				}
+				for k, v := range s.Fields {
+					if k == "GT" {
+						s.Fields[k] = dropGT(v, a)
+						continue
+					}
+					if sf, ok := rdr.Header.SampleFormats[k]; ok {
+						if vals := dropAllele(strings.Split(v, ","), sf.Number, a, n); len(vals) > 0 {
+							s.Fields[k] = strings.Join(vals, ",")
+						} else {
+							s.Fields[k] = "."
+						}
+					}
+				}
+				for i, g := range s.GT {
+					switch {
+					case g == a:
+						s.GT[i] = -1
+					case g > a:
+						s.GT[i] = g - 1
+					}
+				}
+			}
+			variant.Alternate = append(variant.Alternate[:ai], variant.Alternate[ai+1:]...)
+		}
+
+		st.RecordsOut++
+		wrt.WriteVariant(variant)
+	}
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/brentp/vcfgo"
+	"github.com/google/subcommands"
+)
+
+func TestRefBlocks(t *testing.T) {
+	for alts, want := range map[string]bool{
+		"<NON_REF>":     true,
+		"<*>":           true,
+		"<NON_REF>,<*>": true,
+		"G,<NON_REF>":   false,
+		"G":             false,
+		"":              false,
+	} {
+		v := &vcfgo.Variant{Alternate: strings.Split(alts, ",")}
+		if alts == "" {
+			v.Alternate = nil
+		}
+		if got := isRefBlock(v); got != want {
+			t.Errorf("%q: got %v, want %v", alts, got, want)
+		}
+	}
+
+	for _, tc := range []struct {
+		extras []string
+		want   bool
+	}{
+		{[]string{"##GVCFBlock0-20=minGQ=0(inclusive),maxGQ=20(exclusive)"}, true},
+		{[]string{`##ALT=<ID=NON_REF,Description="Represents any possible alternative allele">`}, true},
+		{[]string{`##ALT=<ID=*,Description="Represents allele(s) other than observed.">`}, true},
+		{[]string{`##ALT=<ID=DEL,Description="Deletion">`}, false},
+	} {
+		if got := isGVCF(&vcfgo.Header{Extras: tc.extras}); got != tc.want {
+			t.Errorf("%q: got %v, want %v", tc.extras, got, tc.want)
+		}
+	}
+}
+
+func TestGVCFBlocksSkip(t *testing.T) {
+	h := &vcfgo.Header{Infos: map[string]*vcfgo.Info{}}
+	block := &vcfgo.Variant{Chromosome: "1", Pos: 100, Reference: "A", Alternate: []string{"<NON_REF>"}, Info_: testInfo{}}
+	call := &vcfgo.Variant{Chromosome: "1", Pos: 200, Reference: "A", Alternate: []string{"G", "<NON_REF>"}, Info_: testInfo{}}
+	for _, tc := range []struct {
+		mode    string
+		written int
+	}{{"pass", 1}, {"drop", 0}} {
+		g := &gvcfBlocks{mode: tc.mode}
+		if err := g.begin(h, "rank"); err != nil {
+			t.Fatal(err)
+		}
+		wrt, err := vcfgo.NewWriter(&bytes.Buffer{}, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := &runStats{Counts: map[string]int{}}
+		if !g.skip(block, wrt, st) {
+			t.Errorf("%s: reference block not skipped", tc.mode)
+		}
+		if g.skip(call, wrt, st) {
+			t.Errorf("%s: call with a real alt skipped", tc.mode)
+		}
+		if st.RecordsOut != tc.written || st.Counts["ref_blocks"] != 1 {
+			t.Errorf("%s: records out %d, ref_blocks %d", tc.mode, st.RecordsOut, st.Counts["ref_blocks"])
+		}
+	}
+	if err := (&gvcfBlocks{mode: "keep"}).begin(h, "rank"); err == nil {
+		t.Errorf("-gvcf keep accepted")
+	}
+
+	for _, cmd := range []subcommands.Command{&rank{}, &coords{}, &anchor{}} {
+		f := flag.NewFlagSet(cmd.Name(), flag.ContinueOnError)
+		cmd.SetFlags(f)
+		if fl := f.Lookup("gvcf"); fl == nil || fl.DefValue != "pass" {
+			t.Errorf("%s: -gvcf missing or not defaulting to pass", cmd.Name())
+		}
+	}
+}
+
+func TestDropAllele(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		vals   string
+		number string
+		a, n   int
+		want   string
+	}{
+		{"Number=A first alt", "0.1,0.2", "A", 1, 3, "0.2"},
+		{"Number=A last alt", "0.1,0.2", "A", 2, 3, "0.1"},
+		{"Number=R keeps REF", "10,5,0", "R", 2, 3, "10,5"},
+		{"Number=R middle alt", "10,5,0", "R", 1, 3, "10,0"},
+		// 0/0 0/1 1/1 0/2 1/2 2/2
+		{"diploid PL last alt", "0,30,300,40,310,320", "G", 2, 3, "0,30,300"},
+		{"diploid PL first alt", "0,30,300,40,310,320", "G", 1, 3, "0,40,320"},
+		// 0/0 0/1 1/1 0/2 1/2 2/2 0/3 1/3 2/3 3/3
+		{"diploid PL of three alts", "0,1,2,3,4,5,6,7,8,9", "G", 2, 4, "0,1,2,6,7,9"},
+		{"haploid PL", "0,30,40", "G", 2, 3, "0,30"},
+		{"Number=1 unchanged", "12", "1", 1, 3, "12"},
+		{"Number=. unchanged", "a,b", ".", 1, 3, "a,b"},
+	} {
+		got := strings.Join(dropAllele(strings.Split(tc.vals, ","), tc.number, tc.a, tc.n), ",")
+		if got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDropGT(t *testing.T) {
+	for _, tc := range []struct {
+		gt   string
+		a    int
+		want string
+	}{
+		{"0/1", 2, "0/1"},
+		{"0/2", 2, "0/."},
+		{"1|2", 1, ".|1"},
+		{"2/3", 2, "./2"},
+		{"2", 1, "1"},
+		{"./.", 1, "./."},
+		{"0/0", 1, "0/0"},
+	} {
+		if got := dropGT(tc.gt, tc.a); got != tc.want {
+			t.Errorf("dropGT(%s, %d): got %s, want %s", tc.gt, tc.a, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/brentp/vcfgo"
+)
+
+// infoFields adds a command's INFO fields to the header. An ID the input
+// already defines is refused unless -overwrite is given, or added under ID
+// plus -suffix instead; id maps each requested ID to the one written.
+type infoFields struct {
+	overwrite bool
+	suffix    string
+	ids       map[string]string
+	replaced  []string
+}
+
+func (i *infoFields) setFlags(f *flag.FlagSet) {
+	f.BoolVar(&i.overwrite, "overwrite", false, "replace INFO fields the input header already defines")
+	f.StringVar(&i.suffix, "suffix", "", "add new INFO fields the input header already defines under ID+suffix instead")
+}
+
+func (i *infoFields) add(rdr *vcfgo.Reader, id, number, typ, desc string) error {
+	if i.ids == nil {
+		i.ids = map[string]string{}
+	}
+	if _, ok := i.ids[id]; ok {
+		return fmt.Errorf("INFO field %s is added twice", id)
+	}
+
+	out := id
+	if _, ok := rdr.Header.Infos[id]; ok {
+		switch {
+		case i.overwrite:
+			i.replaced = append(i.replaced, id)
+		case i.suffix != "":
+			out = id + i.suffix
+			if _, ok := rdr.Header.Infos[out]; ok {
+				return fmt.Errorf("INFO fields %s and %s are both in the header already, use -overwrite or another -suffix", id, out)
+			}
+		default:
+			return fmt.Errorf("INFO field %s is in the header already, use -overwrite or -suffix", id)
+		}
+	}
+	i.ids[id] = out
+	rdr.AddInfoToHeader(out, number, typ, desc)
+	return nil
+}
+
+func (i *infoFields) id(id string) string {
+	return i.ids[id]
+}
+
+// clear removes the input's values of overwritten fields, so records the
+// command leaves unset don't keep them
+func (i *infoFields) clear(v *vcfgo.Variant) {
+	for _, id := range i.replaced {
+		v.Info().Delete(id)
+	}
+}
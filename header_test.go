@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/brentp/vcfgo"
+	"github.com/google/subcommands"
+)
+
+func TestInfoFields(t *testing.T) {
+	header := func() *vcfgo.Reader {
+		return &vcfgo.Reader{Header: &vcfgo.Header{Infos: map[string]*vcfgo.Info{
+			"rank":   {Id: "rank", Number: "1", Type: "Float"},
+			"rank_2": {Id: "rank_2", Number: "1", Type: "Float"},
+			"acmg":   {Id: "acmg", Number: "1", Type: "String"},
+		}}}
+	}
+
+	var refused infoFields
+	if err := refused.add(header(), "rank", "1", "Float", ""); err == nil || !strings.Contains(err.Error(), "use -overwrite or -suffix") {
+		t.Errorf("rerun without -overwrite or -suffix: got %v", err)
+	}
+
+	fresh := infoFields{}
+	if err := fresh.add(header(), "comphet_rank", "1", "Float", ""); err != nil || fresh.id("comphet_rank") != "comphet_rank" {
+		t.Errorf("new field: got %v, id %q", err, fresh.id("comphet_rank"))
+	}
+	if err := fresh.add(header(), "comphet_rank", "1", "Float", ""); err == nil {
+		t.Errorf("field added twice accepted")
+	}
+
+	suffixed := infoFields{suffix: "_new"}
+	if err := suffixed.add(header(), "acmg", "1", "String", ""); err != nil || suffixed.id("acmg") != "acmg_new" {
+		t.Errorf("-suffix: got %v, id %q", err, suffixed.id("acmg"))
+	}
+	taken := infoFields{suffix: "_2"}
+	if err := taken.add(header(), "rank", "1", "Float", ""); err == nil {
+		t.Errorf("-suffix onto a field the header has accepted")
+	}
+
+	over := infoFields{overwrite: true}
+	if err := over.add(header(), "rank", "1", "Float", ""); err != nil || over.id("rank") != "rank" {
+		t.Errorf("-overwrite: got %v, id %q", err, over.id("rank"))
+	}
+	v := &vcfgo.Variant{Info_: testInfo{"rank": 2.0, "DP": 10}}
+	over.clear(v)
+	if _, err := v.Info().Get("rank"); err == nil {
+		t.Errorf("-overwrite kept the input's rank value")
+	}
+	if _, err := v.Info().Get("DP"); err != nil {
+		t.Errorf("-overwrite cleared DP")
+	}
+}
+
+// every command adding INFO fields takes -overwrite and -suffix
+func TestInfoFieldFlags(t *testing.T) {
+	for _, cmd := range []subcommands.Command{&manipInfo{}, &coords{}, &pullCSQ{}, &rank{}, &clinvar{}, &acmg{}, &annotate{}, &script{}} {
+		f := flag.NewFlagSet(cmd.Name(), flag.ContinueOnError)
+		cmd.SetFlags(f)
+		for _, name := range []string{"overwrite", "suffix"} {
+			if f.Lookup(name) == nil {
+				t.Errorf("%s has no -%s", cmd.Name(), name)
+			}
+		}
+	}
+}
@@ -0,0 +1,631 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/faidx"
+	"github.com/brentp/vcfgo"
+	"github.com/google/subcommands"
+)
+
+type transcript struct {
+	id     string
+	chrom  string
+	strand byte
+	exons  [][2]int // 1-based inclusive genomic coords, ordered 5' to 3'
+
+	// first and last coding base in transcript coords, 0 when non-coding
+	cdsStart int
+	cdsEnd   int
+}
+
+// txToGenomic converts a 1-based transcript coordinate to genomic, extending
+// past either end of the transcript for upstream/downstream positions.
+func (t *transcript) txToGenomic(n int) int {
+	first := t.exons[0]
+	if n < 1 {
+		if t.strand == '-' {
+			return first[1] + (1 - n)
+		}
+		return first[0] - (1 - n)
+	}
+	for _, e := range t.exons {
+		l := e[1] - e[0] + 1
+		if n <= l {
+			if t.strand == '-' {
+				return e[1] - (n - 1)
+			}
+			return e[0] + n - 1
+		}
+		n -= l
+	}
+	last := t.exons[len(t.exons)-1]
+	if t.strand == '-' {
+		return last[0] - n
+	}
+	return last[1] + n
+}
+
+func (t *transcript) genomicToTx(g int) int {
+	n := 0
+	for _, e := range t.exons {
+		if g >= e[0] && g <= e[1] {
+			if t.strand == '-' {
+				return n + e[1] - g + 1
+			}
+			return n + g - e[0] + 1
+		}
+		n += e[1] - e[0] + 1
+	}
+	return 0
+}
+
+type transcriptIndex struct {
+	byID   map[string]*transcript
+	byBase map[string][]*transcript // keyed by accession without version
+}
+
+func stripVersion(acc string) string {
+	i := strings.LastIndex(acc, ".")
+	if i <= 0 {
+		return acc
+	}
+	if _, err := strconv.Atoi(acc[i+1:]); err != nil {
+		return acc
+	}
+	return acc[:i]
+}
+
+// lookup only falls back to another version of a transcript when the query
+// itself is unversioned, since coordinates can differ between versions.
+func (idx *transcriptIndex) lookup(acc string) (*transcript, error) {
+	if t, ok := idx.byID[acc]; ok {
+		return t, nil
+	}
+	base := stripVersion(acc)
+	cands := idx.byBase[base]
+	if len(cands) == 0 {
+		return nil, fmt.Errorf("transcript %s not in annotation", acc)
+	}
+	if base == acc {
+		return cands[0], nil
+	}
+	var have []string
+	for _, t := range cands {
+		have = append(have, t.id)
+	}
+	return nil, fmt.Errorf("transcript %s not in annotation, found %s", acc, strings.Join(have, ","))
+}
+
+func openMaybeGzip(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return file, nil
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, file}, nil
+}
+
+func parseGFF3Attrs(s string) map[string]string {
+	attrs := map[string]string{}
+	for _, kv := range strings.Split(s, ";") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		if uv, err := url.PathUnescape(v); err == nil {
+			v = uv
+		}
+		attrs[strings.TrimSpace(k)] = v
+	}
+	return attrs
+}
+
+func parseGTFAttrs(s string) map[string]string {
+	attrs := map[string]string{}
+	for _, kv := range strings.Split(s, ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(kv), " ")
+		if !ok {
+			continue
+		}
+		attrs[k] = strings.Trim(strings.TrimSpace(v), `"`)
+	}
+	return attrs
+}
+
+// readTranscripts loads exon and CDS structure from a GFF3 (Ensembl or RefSeq)
+// or GTF file, optionally gzipped. Transcripts are indexed under every name
+// the file gives them: ID, Name and transcript_id with and without version.
+func readTranscripts(path string) (*transcriptIndex, error) {
+	rc, err := openMaybeGzip(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	type part struct {
+		chrom      string
+		kind       string
+		start, end int
+		strand     byte
+	}
+	parts := map[string][]part{}
+	names := map[string][]string{}
+
+	scanner := bufio.NewScanner(rc)
+	scanner.Buffer(make([]byte, 0, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		ls := strings.Split(line, "\t")
+		if len(ls) < 9 || ls[6] == "" {
+			continue
+		}
+		start, err := strconv.Atoi(ls[3])
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad start in %q", path, line)
+		}
+		end, err := strconv.Atoi(ls[4])
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad end in %q", path, line)
+		}
+		p := part{chrom: ls[0], kind: ls[2], start: start, end: end, strand: ls[6][0]}
+
+		if strings.Contains(ls[8], `transcript_id "`) {
+			attrs := parseGTFAttrs(ls[8])
+			id := attrs["transcript_id"]
+			if id == "" {
+				continue
+			}
+			if v := attrs["transcript_version"]; v != "" && stripVersion(id) == id {
+				id += "." + v
+			}
+			names[id] = []string{id}
+			parts[id] = append(parts[id], p)
+			continue
+		}
+
+		attrs := parseGFF3Attrs(ls[8])
+		if id := attrs["ID"]; id != "" {
+			n := []string{id, strings.TrimPrefix(strings.TrimPrefix(id, "rna-"), "transcript:")}
+			if attrs["Name"] != "" {
+				n = append(n, attrs["Name"])
+			}
+			if tid := attrs["transcript_id"]; tid != "" {
+				n = append(n, tid)
+				if v := attrs["version"]; v != "" && stripVersion(tid) == tid {
+					n = append(n, tid+"."+v)
+				}
+			}
+			names[id] = n
+		}
+		if p.kind != "exon" && p.kind != "CDS" {
+			continue
+		}
+		for _, parent := range strings.Split(attrs["Parent"], ",") {
+			if parent != "" {
+				parts[parent] = append(parts[parent], p)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	idx := &transcriptIndex{byID: map[string]*transcript{}, byBase: map[string][]*transcript{}}
+	for key, ps := range parts {
+		t := &transcript{chrom: ps[0].chrom, strand: ps[0].strand}
+		cdsLo, cdsHi := 0, 0
+		for _, p := range ps {
+			switch p.kind {
+			case "exon":
+				t.exons = append(t.exons, [2]int{p.start, p.end})
+			case "CDS", "stop_codon":
+				if cdsLo == 0 || p.start < cdsLo {
+					cdsLo = p.start
+				}
+				if p.end > cdsHi {
+					cdsHi = p.end
+				}
+			}
+		}
+		if len(t.exons) == 0 {
+			continue
+		}
+		sort.Slice(t.exons, func(i, j int) bool {
+			if t.strand == '-' {
+				return t.exons[i][0] > t.exons[j][0]
+			}
+			return t.exons[i][0] < t.exons[j][0]
+		})
+		if cdsLo != 0 {
+			if t.strand == '-' {
+				t.cdsStart, t.cdsEnd = t.genomicToTx(cdsHi), t.genomicToTx(cdsLo)
+			} else {
+				t.cdsStart, t.cdsEnd = t.genomicToTx(cdsLo), t.genomicToTx(cdsHi)
+			}
+		}
+
+		n := names[key]
+		if len(n) == 0 {
+			n = []string{key}
+		}
+		t.id = n[len(n)-1]
+		seen := map[string]bool{}
+		for _, name := range n {
+			idx.byID[name] = t
+			if base := stripVersion(name); !seen[base] {
+				seen[base] = true
+				idx.byBase[base] = append(idx.byBase[base], t)
+			}
+		}
+	}
+	return idx, nil
+}
+
+var (
+	// the accession may carry a gene in brackets, NM_000059.4(BRCA2):c.5946del
+	hgvsRe     = regexp.MustCompile(`^([^:]+):([cng])\.([-*]?\d+)([-+]\d+)?(?:_([-*]?\d+)([-+]\d+)?)?([A-Za-z>]+)$`)
+	hgvsGeneRe = regexp.MustCompile(`\(.*\)`)
+
+	hgvsSubRe    = regexp.MustCompile(`^([ACGTN])>([ACGTN])$`)
+	hgvsDelInsRe = regexp.MustCompile(`^del([ACGTN]*)ins([ACGTN]+)$`)
+	hgvsDelRe    = regexp.MustCompile(`^del([ACGTN]*)$`)
+	hgvsDupRe    = regexp.MustCompile(`^dup([ACGTN]*)$`)
+	hgvsInsRe    = regexp.MustCompile(`^ins([ACGTN]+)$`)
+)
+
+var complement = strings.NewReplacer("A", "T", "C", "G", "G", "C", "T", "A", "N", "N")
+
+func revComp(s string) string {
+	b := []byte(complement.Replace(s))
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
+
+// hgvsLocus resolves one HGVS position (e.g. 123, -12, *34, 123+5) to genomic
+func hgvsLocus(t *transcript, kind string, pos, offset string) (int, error) {
+	var n int
+	switch {
+	case strings.HasPrefix(pos, "*"):
+		if kind != "c" {
+			return 0, fmt.Errorf("%s. positions cannot use *", kind)
+		}
+		p, _ := strconv.Atoi(pos[1:])
+		n = t.cdsEnd + p
+	default:
+		p, _ := strconv.Atoi(pos)
+		anchor := 1
+		if kind == "c" {
+			anchor = t.cdsStart
+		}
+		switch {
+		case p == 0:
+			return 0, fmt.Errorf("position 0 is not valid HGVS")
+		case p < 0:
+			n = anchor + p
+		default:
+			n = anchor + p - 1
+		}
+	}
+
+	g := t.txToGenomic(n)
+	if offset != "" {
+		o, _ := strconv.Atoi(offset)
+		if t.strand == '-' {
+			o = -o
+		}
+		g += o
+	}
+	return g, nil
+}
+
+// normalizeVariant left-aligns and trims an allele pair, anchoring empty
+// alleles on the preceding reference base as VCF requires.
+func normalizeVariant(fetch func(pos int) (string, error), pos int, ref, alt string) (int, string, string, error) {
+	for {
+		changed := false
+		if len(ref) > 0 && len(alt) > 0 && ref[len(ref)-1] == alt[len(alt)-1] {
+			ref, alt = ref[:len(ref)-1], alt[:len(alt)-1]
+			changed = true
+		}
+		if len(ref) == 0 || len(alt) == 0 {
+			if pos <= 1 {
+				return 0, "", "", fmt.Errorf("cannot anchor variant at the start of the contig")
+			}
+			pos--
+			b, err := fetch(pos)
+			if err != nil {
+				return 0, "", "", err
+			}
+			ref, alt = b+ref, b+alt
+			changed = true
+		}
+		if !changed {
+			break
+		}
+	}
+	for len(ref) > 1 && len(alt) > 1 && ref[0] == alt[0] {
+		ref, alt = ref[1:], alt[1:]
+		pos++
+	}
+	return pos, ref, alt, nil
+}
+
+type hgvsMapper struct {
+	idx    *transcriptIndex
+	fa     *faidx.Faidx
+	chroms map[string]string // annotation seqid -> reference contig
+}
+
+// contig finds the reference name for an annotation seqid, which may be a
+// RefSeq accession (NC_000013.11) while the FASTA uses 13 or chr13.
+func (m *hgvsMapper) contig(seqid string) (string, error) {
+	if c, ok := m.chroms[seqid]; ok {
+		return c, nil
+	}
+	plain := strings.TrimPrefix(refseqChrom(seqid), "chr")
+	cands := []string{seqid, plain, "chr" + plain}
+	if plain == "MT" {
+		cands = append(cands, "chrM")
+	}
+	for _, c := range cands {
+		if _, err := m.fa.Get(c, 0, 1); err == nil {
+			m.chroms[seqid] = c
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("contig %s not in reference", seqid)
+}
+
+func (m *hgvsMapper) seq(chrom string, start, end int) (string, error) {
+	s, err := m.fa.Get(chrom, start-1, end)
+	return strings.ToUpper(s), err
+}
+
+// toVCF converts one c., n. or g. HGVS description to a normalized VCF allele
+func (m *hgvsMapper) toVCF(hgvs string) (string, int, string, string, error) {
+	mt := hgvsRe.FindStringSubmatch(hgvs)
+	if mt == nil {
+		return "", 0, "", "", fmt.Errorf("could not parse HGVS")
+	}
+	acc := hgvsGeneRe.ReplaceAllString(mt[1], "")
+	kind := mt[2]
+
+	var t *transcript
+	var err error
+	if kind == "g" {
+		t = &transcript{id: acc, chrom: acc, strand: '+', exons: [][2]int{{1, 1}}}
+	} else {
+		t, err = m.idx.lookup(acc)
+		if err != nil {
+			return "", 0, "", "", err
+		}
+		if kind == "c" && t.cdsStart == 0 {
+			return "", 0, "", "", fmt.Errorf("c. notation used for non-coding transcript %s", t.id)
+		}
+	}
+
+	chrom, err := m.contig(t.chrom)
+	if err != nil {
+		return "", 0, "", "", err
+	}
+
+	g1, err := hgvsLocus(t, kind, mt[3], mt[4])
+	if err != nil {
+		return "", 0, "", "", err
+	}
+	g2 := g1
+	if mt[5] != "" {
+		g2, err = hgvsLocus(t, kind, mt[5], mt[6])
+		if err != nil {
+			return "", 0, "", "", err
+		}
+	}
+	lo, hi := g1, g2
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	if lo < 1 {
+		return "", 0, "", "", fmt.Errorf("position falls before the start of %s", chrom)
+	}
+
+	orient := func(s string) string {
+		if t.strand == '-' {
+			return revComp(s)
+		}
+		return s
+	}
+	span, err := m.seq(chrom, lo, hi)
+	if err != nil {
+		return "", 0, "", "", err
+	}
+	checkRef := func(listed string) error {
+		if listed != "" && orient(listed) != span {
+			return fmt.Errorf("reference mismatch: HGVS has %s, reference has %s", listed, orient(span))
+		}
+		return nil
+	}
+
+	var pos int
+	var ref, alt string
+	edit := mt[7]
+	switch {
+	case hgvsSubRe.MatchString(edit):
+		e := hgvsSubRe.FindStringSubmatch(edit)
+		if lo != hi {
+			return "", 0, "", "", fmt.Errorf("substitution spans more than one base")
+		}
+		if err := checkRef(e[1]); err != nil {
+			return "", 0, "", "", err
+		}
+		pos, ref, alt = lo, span, orient(e[2])
+	case hgvsDelInsRe.MatchString(edit):
+		e := hgvsDelInsRe.FindStringSubmatch(edit)
+		if err := checkRef(e[1]); err != nil {
+			return "", 0, "", "", err
+		}
+		pos, ref, alt = lo, span, orient(e[2])
+	case hgvsDelRe.MatchString(edit):
+		if err := checkRef(hgvsDelRe.FindStringSubmatch(edit)[1]); err != nil {
+			return "", 0, "", "", err
+		}
+		pos, ref, alt = lo, span, ""
+	case hgvsDupRe.MatchString(edit):
+		if err := checkRef(hgvsDupRe.FindStringSubmatch(edit)[1]); err != nil {
+			return "", 0, "", "", err
+		}
+		pos, ref, alt = hi+1, "", span
+	case hgvsInsRe.MatchString(edit):
+		if hi != lo+1 {
+			return "", 0, "", "", fmt.Errorf("insertion must be between two adjacent bases")
+		}
+		pos, ref, alt = hi, "", orient(hgvsInsRe.FindStringSubmatch(edit)[1])
+	default:
+		return "", 0, "", "", fmt.Errorf("unsupported edit %q", edit)
+	}
+	if ref == alt {
+		return "", 0, "", "", fmt.Errorf("edit does not change the reference")
+	}
+
+	fetch := func(p int) (string, error) { return m.seq(chrom, p, p) }
+	pos, ref, alt, err = normalizeVariant(fetch, pos, ref, alt)
+	return chrom, pos, ref, alt, err
+}
+
+type hgvs2vcf struct {
+	annotation string
+	reference  string
+	unmapped   string
+}
+
+func (*hgvs2vcf) Name() string { return "hgvs2vcf" }
+func (*hgvs2vcf) Synopsis() string {
+	return "convert HGVS c./n./g. variants (one per line, optional sample after whitespace) to normalized vcf"
+}
+func (*hgvs2vcf) Usage() string {
+	return `hgvs2vcf -annotation genes.gff3 -reference ref.fa [-unmapped unmapped.txt] < variants.txt
+
+Lines that can not be mapped are counted as unmapped and skipped, written
+with the reason to -unmapped when it is set and to stderr otherwise.
+`
+}
+
+func (h *hgvs2vcf) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&h.annotation, "annotation", "", "transcript annotation (GFF3, GTF or RefSeq GFF, optionally gzipped)")
+	f.StringVar(&h.reference, "reference", "", "reference fasta, indexed")
+	f.StringVar(&h.unmapped, "unmapped", "", "also write lines that could not be mapped, with the reason, to this file")
+}
+
+func (h *hgvs2vcf) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := statsFrom(args)
+	ep := errPolicyFrom(args)
+	idx, err := readTranscripts(h.annotation)
+	if err != nil {
+		return ep.fatal(err)
+	}
+
+	fa, err := faidx.New(h.reference)
+	if err != nil {
+		return ep.fatal(err)
+	}
+
+	var unmapped io.Writer = io.Discard
+	if h.unmapped != "" {
+		file, err := os.Create(h.unmapped)
+		if err != nil {
+			return ep.fatal(err)
+		}
+		defer file.Close()
+		unmapped = file
+	}
+
+	m := &hgvsMapper{idx: idx, fa: fa, chroms: map[string]string{}}
+
+	hdr := vcfgo.NewHeader()
+	hdr.FileFormat = "4.2"
+	hdr.Infos["hgvs"] = &vcfgo.Info{
+		Id:          "hgvs",
+		Description: "HGVS description the record was converted from",
+		Number:      "1",
+		Type:        "String",
+	}
+	hdr.Infos["sample"] = &vcfgo.Info{
+		Id:          "sample",
+		Description: "samples",
+		Number:      ".",
+		Type:        "String",
+	}
+
+	addProvenance(hdr, ep.cmd)
+	wrt, err := vcfgo.NewWriter(os.Stdout, hdr)
+	if err != nil {
+		return ep.fatal(err)
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		st.RecordsIn++
+		chrom, pos, ref, alt, err := m.toVCF(fields[0])
+		if err != nil {
+			st.count("unmapped")
+			if h.unmapped != "" {
+				fmt.Fprintf(unmapped, "%s\t%s\n", scanner.Text(), err)
+			} else {
+				fmt.Fprintf(os.Stderr, "%s: %s: could not map %s: %s\n", ep.cmd, lineWhere("stdin", n), fields[0], err)
+			}
+			continue
+		}
+
+		variant := &vcfgo.Variant{
+			Chromosome: chrom,
+			Pos:        uint64(pos),
+			Id_:        ".",
+			Reference:  ref,
+			Alternate:  []string{alt},
+			Header:     hdr,
+			Filter:     ".",
+			Info_:      vcfgo.NewInfoByte([]byte{}, hdr),
+		}
+		_ = variant.Info().Set("hgvs", fields[0])
+		if len(fields) > 1 {
+			_ = variant.Info().Set("sample", fields[1])
+		}
+		st.RecordsOut++
+		wrt.WriteVariant(variant)
+	}
+	if err := scanner.Err(); err != nil {
+		return ep.fatal(err)
+	}
+	return subcommands.ExitSuccess
+}
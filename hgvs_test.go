@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testTranscripts have exons of 10, 20 and 10 bases with the CDS running
+// from transcript base 5 to 35, on each strand
+func testTranscripts() (plus, minus *transcript) {
+	plus = &transcript{id: "NM_PLUS", strand: '+', exons: [][2]int{{100, 109}, {200, 219}, {300, 309}}, cdsStart: 5, cdsEnd: 35}
+	minus = &transcript{id: "NM_MINUS", strand: '-', exons: [][2]int{{300, 309}, {200, 219}, {100, 109}}, cdsStart: 5, cdsEnd: 35}
+	return plus, minus
+}
+
+func TestHGVSLocus(t *testing.T) {
+	plus, minus := testTranscripts()
+	for _, tc := range []struct {
+		name        string
+		t           *transcript
+		kind        string
+		pos, offset string
+		want        int
+	}{
+		{"first coding base", plus, "c", "1", "", 104},
+		{"first base of the transcript", plus, "c", "-4", "", 100},
+		{"upstream of the transcript", plus, "c", "-5", "", 99},
+		{"last base of exon 1", plus, "c", "6", "", 109},
+		{"first base of exon 2", plus, "c", "7", "", 200},
+		{"intron after exon 1", plus, "c", "6", "+2", 111},
+		{"intron before exon 2", plus, "c", "7", "-3", 197},
+		{"first base after the stop", plus, "c", "*1", "", 305},
+		{"downstream of the transcript", plus, "c", "*6", "", 310},
+		{"non-coding first base", plus, "n", "1", "", 100},
+		{"non-coding upstream", plus, "n", "-1", "", 99},
+		{"non-coding exon 2", plus, "n", "11", "", 200},
+
+		{"minus first coding base", minus, "c", "1", "", 305},
+		{"minus upstream of the transcript", minus, "c", "-5", "", 310},
+		{"minus last base of exon 1", minus, "c", "6", "", 300},
+		{"minus first base of exon 2", minus, "c", "7", "", 219},
+		{"minus intron after exon 1", minus, "c", "6", "+2", 298},
+		{"minus intron before exon 2", minus, "c", "7", "-3", 222},
+		{"minus first base after the stop", minus, "c", "*1", "", 104},
+		{"minus downstream of the transcript", minus, "c", "*6", "", 99},
+	} {
+		got, err := hgvsLocus(tc.t, tc.kind, tc.pos, tc.offset)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: %s.%s%s got %d, want %d", tc.name, tc.kind, tc.pos, tc.offset, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct{ kind, pos, want string }{
+		{"c", "0", "position 0 is not valid HGVS"},
+		{"n", "*1", "n. positions cannot use *"},
+	} {
+		if _, err := hgvsLocus(plus, tc.kind, tc.pos, ""); err == nil || err.Error() != tc.want {
+			t.Errorf("%s.%s: got %v, want %s", tc.kind, tc.pos, err, tc.want)
+		}
+	}
+}
+
+func TestGenomicToTx(t *testing.T) {
+	plus, minus := testTranscripts()
+	for _, tr := range []*transcript{plus, minus} {
+		for n := 1; n <= 40; n++ {
+			if got := tr.genomicToTx(tr.txToGenomic(n)); got != n {
+				t.Errorf("%s: transcript base %d came back as %d", tr.id, n, got)
+			}
+		}
+		if got := tr.genomicToTx(150); got != 0 {
+			t.Errorf("%s: intronic base got %d, want 0", tr.id, got)
+		}
+	}
+}
+
+func TestNormalizeVariant(t *testing.T) {
+	// 1 based: G C A C A C A T
+	seq := "GCACACAT"
+	fetch := func(p int) (string, error) {
+		if p < 1 || p > len(seq) {
+			return "", fmt.Errorf("position %d outside the contig", p)
+		}
+		return seq[p-1 : p], nil
+	}
+	for _, tc := range []struct {
+		name          string
+		pos           int
+		ref, alt      string
+		wantPos       int
+		wantRef, want string
+	}{
+		{"snv unchanged", 3, "A", "G", 3, "A", "G"},
+		{"shared first base trimmed", 2, "CA", "CG", 3, "A", "G"},
+		{"deletion left aligned in a repeat", 4, "CA", "", 1, "GCA", "G"},
+		{"insertion left aligned in a repeat", 8, "", "CA", 1, "G", "GCA"},
+		{"deletion outside a repeat anchored", 8, "T", "", 7, "AT", "A"},
+	} {
+		pos, ref, alt, err := normalizeVariant(fetch, tc.pos, tc.ref, tc.alt)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if pos != tc.wantPos || ref != tc.wantRef || alt != tc.want {
+			t.Errorf("%s: got %d %s>%s, want %d %s>%s", tc.name, pos, ref, alt, tc.wantPos, tc.wantRef, tc.want)
+		}
+	}
+
+	if _, _, _, err := normalizeVariant(fetch, 1, "G", ""); err == nil || !strings.Contains(err.Error(), "start of the contig") {
+		t.Errorf("deletion of the first base: got %v", err)
+	}
+	if got := revComp("ACGTN"); got != "NACGT" {
+		t.Errorf("revComp got %s", got)
+	}
+}
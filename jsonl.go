@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/vcfgo"
+	"github.com/google/subcommands"
+)
+
+// jsonMeta is an INFO or FORMAT header line
+type jsonMeta struct {
+	ID          string `json:"id"`
+	Number      string `json:"number"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+// jsonHeader is the first line toJSON writes, all fromJSON needs to write
+// the VCF header back
+type jsonHeader struct {
+	FileFormat string              `json:"fileformat"`
+	Infos      []jsonMeta          `json:"info"`
+	Formats    []jsonMeta          `json:"format"`
+	Filters    map[string]string   `json:"filter,omitempty"`
+	Contigs    []map[string]string `json:"contigs,omitempty"`
+	Extras     []string            `json:"extras,omitempty"`
+	Samples    []string            `json:"samples"`
+}
+
+type jsonRecord struct {
+	Chrom   string                            `json:"chrom"`
+	Pos     uint64                            `json:"pos"`
+	ID      string                            `json:"id"`
+	Ref     string                            `json:"ref"`
+	Alt     []string                          `json:"alt"`
+	Qual    float64                           `json:"qual"`
+	Filter  string                            `json:"filter"`
+	Info    map[string]interface{}            `json:"info"`
+	CSQ     []map[string]string               `json:"csq,omitempty"`
+	Format  []string                          `json:"format,omitempty"`
+	Samples map[string]map[string]interface{} `json:"samples,omitempty"`
+}
+
+func headerToJSON(h *vcfgo.Header) *jsonHeader {
+	jh := &jsonHeader{FileFormat: h.FileFormat, Filters: h.Filters, Contigs: h.Contigs, Extras: h.Extras, Samples: h.SampleNames}
+	for id, i := range h.Infos {
+		jh.Infos = append(jh.Infos, jsonMeta{id, i.Number, i.Type, i.Description})
+	}
+	for id, f := range h.SampleFormats {
+		jh.Formats = append(jh.Formats, jsonMeta{id, f.Number, f.Type, f.Description})
+	}
+	sort.Slice(jh.Infos, func(i, j int) bool { return jh.Infos[i].ID < jh.Infos[j].ID })
+	sort.Slice(jh.Formats, func(i, j int) bool { return jh.Formats[i].ID < jh.Formats[j].ID })
+	return jh
+}
+
+func (jh *jsonHeader) vcfHeader() *vcfgo.Header {
+	h := vcfgo.NewHeader()
+	h.FileFormat = jh.FileFormat
+	h.Infos = map[string]*vcfgo.Info{}
+	for _, m := range jh.Infos {
+		h.Infos[m.ID] = &vcfgo.Info{Id: m.ID, Number: m.Number, Type: m.Type, Description: m.Description}
+	}
+	h.SampleFormats = map[string]*vcfgo.SampleFormat{}
+	for _, m := range jh.Formats {
+		h.SampleFormats[m.ID] = &vcfgo.SampleFormat{Id: m.ID, Number: m.Number, Type: m.Type, Description: m.Description}
+	}
+	h.Filters = jh.Filters
+	if h.Filters == nil {
+		h.Filters = map[string]string{}
+	}
+	h.Contigs = jh.Contigs
+	h.Extras = jh.Extras
+	h.SampleNames = jh.Samples
+	return h
+}
+
+// typedValue converts the parts of an INFO or FORMAT value to its header
+// Type, a single value for Number=1 and a list otherwise. Missing parts are
+// null.
+func typedValue(vals []string, typ, number string) interface{} {
+	if typ == "Flag" {
+		return true
+	}
+	if number == "1" && typ != "Integer" && typ != "Float" {
+		return strings.Join(vals, ",")
+	}
+	items := make([]interface{}, len(vals))
+	for i, s := range vals {
+		switch {
+		case s == "." || s == "":
+			items[i] = nil
+		case typ == "Integer":
+			if n, err := strconv.Atoi(s); err == nil {
+				items[i] = n
+			} else {
+				items[i] = s
+			}
+		case typ == "Float":
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				items[i] = f
+			} else {
+				items[i] = s
+			}
+		default:
+			items[i] = s
+		}
+	}
+	if number == "1" && len(items) == 1 {
+		return items[0]
+	}
+	return items
+}
+
+// vcfValue is the VCF text of a value decoded with UseNumber
+func vcfValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "."
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case []interface{}:
+		s := make([]string, len(v))
+		for i, it := range v {
+			s[i] = vcfValue(it)
+		}
+		return strings.Join(s, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+type toJSON struct{}
+
+func (*toJSON) Name() string { return "toJSON" }
+func (*toJSON) Synopsis() string {
+	return "write vcf records as JSON Lines with typed INFO, FORMAT and parsed CSQ"
+}
+func (*toJSON) Usage() string {
+	return `toJSON < in.vcf > out.jsonl
+
+The first line is {"header": ...} with the INFO and FORMAT definitions,
+filters, contigs, other header lines and samples. Each record after is
+  {"chrom", "pos", "id", "ref", "alt": [...], "qual", "filter",
+   "info": {key: value}, "csq": [{field: value}], "format": [keys],
+   "samples": {name: {key: value}}}
+INFO and FORMAT values follow the header: Integer and Float are numbers,
+Flag true, Number=1 a single value and other Numbers lists, with null for
+missing entries. csq is CSQ split into its fields, one object per entry.
+fromJSON turns the output back into a vcf.
+`
+}
+
+func (*toJSON) SetFlags(f *flag.FlagSet) {}
+
+func (*toJSON) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := statsFrom(args)
+	ep := errPolicyFrom(args)
+	rdr, err := vcfgo.NewReader(os.Stdin, false)
+	if err != nil {
+		return ep.fatal(err)
+	}
+	var csqKeys []string
+	if _, ok := rdr.Header.Infos["CSQ"]; ok {
+		if csqKeys, err = getCSQKeys(rdr.Header); err != nil {
+			return ep.fatal(err)
+		}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(map[string]*jsonHeader{"header": headerToJSON(rdr.Header)}); err != nil {
+		return ep.fatal(err)
+	}
+
+	for {
+		variant := rdr.Read()
+		if variant == nil {
+			break
+		}
+		st.RecordsIn++
+		if err := readErr(rdr); err != nil {
+			if !ep.record(variantWhere(variant), err) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+
+		rec := jsonRecord{
+			Chrom:  variant.Chromosome,
+			Pos:    variant.Pos,
+			ID:     variant.Id_,
+			Ref:    variant.Reference,
+			Alt:    variant.Alternate,
+			Qual:   float64(variant.Quality),
+			Filter: variant.Filter,
+			Info:   map[string]interface{}{},
+			Format: variant.Format,
+		}
+		for _, k := range variant.Info().Keys() {
+			val, err := variant.Info().Get(k)
+			if err != nil {
+				continue
+			}
+			typ, number := "String", "."
+			if i, ok := rdr.Header.Infos[k]; ok {
+				typ, number = i.Type, i.Number
+			}
+			rec.Info[k] = typedValue(infoStrings(val), typ, number)
+		}
+		if csqKeys != nil {
+			rec.CSQ = getCSQ(variant, csqKeys)
+		}
+
+		if len(variant.Samples) > 0 {
+			rec.Samples = map[string]map[string]interface{}{}
+		}
+		for i, s := range variant.Samples {
+			if i >= len(rdr.Header.SampleNames) || s == nil {
+				continue
+			}
+			fields := map[string]interface{}{}
+			for _, k := range variant.Format {
+				v, ok := sampleField(s, k)
+				if !ok {
+					continue
+				}
+				typ, number := "String", "."
+				if sf, ok := rdr.Header.SampleFormats[k]; ok {
+					typ, number = sf.Type, sf.Number
+				}
+				fields[k] = typedValue(strings.Split(v, ","), typ, number)
+			}
+			rec.Samples[rdr.Header.SampleNames[i]] = fields
+		}
+
+		if err := enc.Encode(rec); err != nil {
+			return ep.fatal(err)
+		}
+		st.RecordsOut++
+	}
+	return subcommands.ExitSuccess
+}
+
+type fromJSON struct{}
+
+func (*fromJSON) Name() string { return "fromJSON" }
+func (*fromJSON) Synopsis() string {
+	return "write JSON Lines from toJSON back out as a vcf"
+}
+func (*fromJSON) Usage() string {
+	return `fromJSON < in.jsonl > out.vcf
+
+Reads the header line and records toJSON writes. CSQ is taken from
+info.CSQ, or rebuilt from csq when info has no CSQ.
+`
+}
+
+func (*fromJSON) SetFlags(f *flag.FlagSet) {}
+
+// parseGT reads a GT string such as 0|1 into alleles, -1 for missing
+func parseGT(gt string) ([]int, bool) {
+	phased := strings.Contains(gt, "|")
+	var alleles []int
+	for _, a := range strings.FieldsFunc(gt, func(r rune) bool { return r == '/' || r == '|' }) {
+		n, err := strconv.Atoi(a)
+		if err != nil {
+			n = -1
+		}
+		alleles = append(alleles, n)
+	}
+	return alleles, phased
+}
+
+func (*fromJSON) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := statsFrom(args)
+	ep := errPolicyFrom(args)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 256*1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return ep.fatal(err)
+		}
+		return ep.fatal(fmt.Errorf("empty input, expected a toJSON header line"))
+	}
+	var first struct {
+		Header *jsonHeader `json:"header"`
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &first); err != nil || first.Header == nil {
+		return ep.fatal(fmt.Errorf("%s: first line is not a toJSON header", lineWhere("stdin", 1)))
+	}
+	hdr := first.Header.vcfHeader()
+	var csqKeys []string
+	if _, ok := hdr.Infos["CSQ"]; ok {
+		keys, err := getCSQKeys(hdr)
+		if err != nil {
+			return ep.fatal(err)
+		}
+		csqKeys = keys
+	}
+
+	addProvenance(hdr, ep.cmd)
+	wrt, err := vcfgo.NewWriter(os.Stdout, hdr)
+	if err != nil {
+		return ep.fatal(err)
+	}
+
+	n := 1
+	for scanner.Scan() {
+		n++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		st.RecordsIn++
+
+		var rec jsonRecord
+		dec := json.NewDecoder(strings.NewReader(scanner.Text()))
+		dec.UseNumber()
+		if err := dec.Decode(&rec); err != nil {
+			if !ep.record(lineWhere("stdin", n), err) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+
+		variant := &vcfgo.Variant{
+			Chromosome: rec.Chrom,
+			Pos:        rec.Pos,
+			Id_:        rec.ID,
+			Reference:  rec.Ref,
+			Alternate:  rec.Alt,
+			Quality:    float32(rec.Qual),
+			Filter:     rec.Filter,
+			Header:     hdr,
+			Info_:      vcfgo.NewInfoByte([]byte{}, hdr),
+			Format:     rec.Format,
+		}
+		if variant.Id_ == "" {
+			variant.Id_ = "."
+		}
+		if variant.Filter == "" {
+			variant.Filter = "."
+		}
+
+		keys := make([]string, 0, len(rec.Info))
+		for k := range rec.Info {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if b, ok := rec.Info[k].(bool); ok {
+				if b {
+					_ = variant.Info().Set(k, true)
+				}
+				continue
+			}
+			_ = variant.Info().Set(k, vcfValue(rec.Info[k]))
+		}
+		if _, ok := rec.Info["CSQ"]; !ok && len(rec.CSQ) > 0 && csqKeys != nil {
+			entries := make([]string, len(rec.CSQ))
+			for i, c := range rec.CSQ {
+				vals := make([]string, len(csqKeys))
+				for j, k := range csqKeys {
+					vals[j] = c[k]
+				}
+				entries[i] = strings.Join(vals, "|")
+			}
+			_ = variant.Info().Set("CSQ", strings.Join(entries, ","))
+		}
+
+		if len(rec.Samples) > 0 {
+			variant.Samples = make([]*vcfgo.SampleGenotype, len(hdr.SampleNames))
+			for i, name := range hdr.SampleNames {
+				s := &vcfgo.SampleGenotype{Fields: map[string]string{}}
+				for _, k := range rec.Format {
+					v, ok := rec.Samples[name][k]
+					if !ok {
+						continue
+					}
+					s.Fields[k] = vcfValue(v)
+				}
+				if gt, ok := s.Fields["GT"]; ok {
+					s.GT, s.Phased = parseGT(gt)
+				}
+				if dp, err := strconv.Atoi(s.Fields["DP"]); err == nil {
+					s.DP = dp
+				}
+				if gq, err := strconv.Atoi(s.Fields["GQ"]); err == nil {
+					s.GQ = gq
+				}
+				variant.Samples[i] = s
+			}
+		}
+
+		st.RecordsOut++
+		wrt.WriteVariant(variant)
+	}
+	if err := scanner.Err(); err != nil {
+		return ep.fatal(err)
+	}
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/brentp/vcfgo"
+)
+
+// missingPredicates are the rank checks a missing value policy applies to
+// and the fields each reads:
+//
+//	af          eAF_popmax/gAF_popmax and TOPMed_AF
+//	dmis        CADD_phred and REVEL_score
+//	splice      spliceAI_max
+//	constraint  the -constrained metric
+//	phom        phom
+//	pchet       pchet
+var missingPredicates = []string{"af", "dmis", "splice", "constraint", "phom", "pchet"}
+
+// missingPolicy is what each predicate concludes when all the fields it
+// reads are missing: pass, fail, or flag, which fails the check and gives
+// the variant the unannotated rank if no tier matches
+type missingPolicy map[string]string
+
+// parseMissingPolicy reads -missing-af and the -missing predicate=policy
+// list. Missing frequencies pass (rare) and every other check fails unless
+// set otherwise.
+func parseMissingPolicy(af, list string) (missingPolicy, error) {
+	p := missingPolicy{}
+	for _, k := range missingPredicates {
+		p[k] = "fail"
+	}
+	switch af {
+	case "rare":
+		p["af"] = "pass"
+	case "common":
+		p["af"] = "fail"
+	case "flag":
+		p["af"] = "flag"
+	default:
+		return nil, fmt.Errorf("-missing-af must be rare, common or flag")
+	}
+	if list == "" {
+		return p, nil
+	}
+	for _, kv := range strings.Split(list, ",") {
+		ls := strings.SplitN(kv, "=", 2)
+		if len(ls) != 2 {
+			return nil, fmt.Errorf("-missing: %q is not predicate=policy", kv)
+		}
+		if _, ok := p[ls[0]]; !ok {
+			return nil, fmt.Errorf("-missing: unknown predicate %q, expected one of %s", ls[0], strings.Join(missingPredicates, ", "))
+		}
+		switch ls[1] {
+		case "pass", "fail", "flag":
+		default:
+			return nil, fmt.Errorf("-missing: policy for %s must be pass, fail or flag", ls[0])
+		}
+		p[ls[0]] = ls[1]
+	}
+	return p, nil
+}
+
+func (p missingPolicy) flags() bool {
+	for _, v := range p {
+		if v == "flag" {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultMissing is rank's policy without -missing-af or -missing, used by
+// rankers such as acmg's and script's that have none of their own
+var defaultMissing, _ = parseMissingPolicy("rare", "")
+
+// missingPass applies the policy for a predicate whose fields are all
+// missing, noting flagged predicates for rank_unannotated
+func (rk *ranker) missingPass(pred string) bool {
+	policy := rk.missing
+	if policy == nil {
+		policy = defaultMissing
+	}
+	switch policy[pred] {
+	case "pass":
+		return true
+	case "flag":
+		for _, f := range rk.flagged {
+			if f == pred {
+				return false
+			}
+		}
+		rk.flagged = append(rk.flagged, pred)
+	}
+	return false
+}
+
+// unannotated notes, as its own step of the explanation, that a flagged
+// predicate was missing its fields
+func (rk *ranker) unannotated() bool {
+	if len(rk.flagged) == 0 {
+		return false
+	}
+	rk.ex.begin("unannotated")
+	return rk.ex.end(rk.ex.note("missing", true, strings.Join(rk.flagged, "&")))
+}
+
+func infoNumber(v *vcfgo.Variant, key string) (float64, bool) {
+	xI, _ := v.Info().Get(key)
+	x, ok := xI.(float64)
+	return x, ok
+}
+
+// popAFs returns the gnomAD popmax and TOPMed allele frequencies. A missing
+// one is 0 when the other is set; the af policy only applies when neither is.
+func (rk *ranker) popAFs(v *vcfgo.Variant) (float64, float64) {
+	_, s := gnomADDetail(v)
+	topMed, ok := infoNumber(v, "TOPMed_AF")
+	if s == "." && !ok && !rk.missingPass("af") {
+		return 1.0, 1.0
+	}
+	return getGnomAD(v), topMed
+}
+
+const popmaxField = "eAF_popmax/gAF_popmax"
+
+// missingCounts tallies the records lacking each field the tiers read
+type missingCounts struct {
+	records int
+	metric  string
+	fields  []string
+	counts  map[string]int
+}
+
+func newMissingCounts(rk *ranker) *missingCounts {
+	c := rk.constrained
+	if c.metric == "" {
+		c = defaultConstrained
+	}
+	return &missingCounts{
+		metric: c.metric,
+		fields: []string{popmaxField, "TOPMed_AF", "vep_Consequence", "vep_IMPACT", "CADD_phred", "REVEL_score",
+			"spliceAI_max", c.metric, "phom", "pchet"},
+		counts: map[string]int{},
+	}
+}
+
+// add counts the fields missing from v
+func (m *missingCounts) add(rk *ranker, v *vcfgo.Variant) {
+	m.records++
+	for _, f := range m.fields {
+		var ok bool
+		switch {
+		case f == popmaxField:
+			_, s := gnomADDetail(v)
+			ok = s != "."
+		case f == m.metric:
+			_, _, ok = rk.constraintValue(v, constraintCutoff{metric: f})
+		default:
+			ok = infoFirst(v, f) != ""
+		}
+		if !ok {
+			m.counts[f]++
+		}
+	}
+}
+
+func (m *missingCounts) summary(w io.Writer) {
+	fmt.Fprintf(w, "rank: %d records, missing values per field:\n", m.records)
+	for _, f := range m.fields {
+		fmt.Fprintf(w, "  %-24s %d\n", f, m.counts[f])
+	}
+}
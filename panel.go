@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/brentp/vcfgo"
+)
+
+type panelGene struct {
+	symbol    string
+	dominant  bool
+	recessive bool
+}
+
+// genePanel maps every identifier of a gene (symbol, aliases, HGNC ID,
+// Ensembl gene ID) to the gene, upper cased.
+type genePanel struct {
+	genes   map[string]*panelGene
+	csqKeys []string
+}
+
+func newGenePanel() *genePanel {
+	return &genePanel{genes: map[string]*panelGene{}}
+}
+
+func normGeneID(id string) string {
+	id = strings.ToUpper(strings.TrimSpace(id))
+	return strings.TrimPrefix(id, "HGNC:")
+}
+
+func (p *genePanel) add(g *panelGene, ids ...string) {
+	for _, id := range ids {
+		if id = normGeneID(id); id != "" && id != "." {
+			p.genes[id] = g
+		}
+	}
+}
+
+// parseMOI reads mode of inheritance as written in OMIM/PanelApp style
+// panels (AD, AR, XLR, MONOALLELIC, BIALLELIC, BOTH ...). Unknown modes
+// allow both dominant and recessive tiers.
+func parseMOI(moi string) (dominant bool, recessive bool) {
+	for _, tok := range strings.FieldsFunc(strings.ToUpper(moi), func(r rune) bool {
+		return !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) {
+		switch tok {
+		case "AD", "XLD", "YL", "MT", "MONOALLELIC", "DOMINANT", "MITOCHONDRIAL":
+			dominant = true
+		case "AR", "XLR", "BIALLELIC", "RECESSIVE", "HEMIZYGOUS":
+			recessive = true
+		case "BOTH":
+			dominant, recessive = true, true
+		}
+	}
+	if !dominant && !recessive {
+		return true, true
+	}
+	return dominant, recessive
+}
+
+// readGenePanel loads a TSV with columns symbol, hgnc_id, ensembl_gene_id,
+// moi and optionally aliases (comma separated). A header line naming those
+// columns may reorder them.
+func (p *genePanel) readGenePanel(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	cols := map[string]int{"symbol": 0, "hgnc_id": 1, "ensembl_gene_id": 2, "moi": 3, "aliases": 4}
+	get := func(ls []string, col string) string {
+		if i, ok := cols[col]; ok && i < len(ls) {
+			return strings.TrimSpace(ls[i])
+		}
+		return ""
+	}
+
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		ls := strings.Split(strings.TrimPrefix(line, "#"), "\t")
+		if n == 1 && (strings.HasPrefix(line, "#") || strings.EqualFold(strings.TrimSpace(ls[0]), "symbol")) {
+			cols = map[string]int{}
+			for i, c := range ls {
+				cols[strings.ToLower(strings.TrimSpace(c))] = i
+			}
+			if _, ok := cols["symbol"]; !ok {
+				return fmt.Errorf("%s: header has no symbol column", path)
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		g := &panelGene{symbol: get(ls, "symbol")}
+		g.dominant, g.recessive = parseMOI(get(ls, "moi"))
+		p.add(g, g.symbol, get(ls, "hgnc_id"), get(ls, "ensembl_gene_id"))
+		p.add(g, strings.Split(get(ls, "aliases"), ",")...)
+	}
+	return scanner.Err()
+}
+
+// match returns the panel gene the variant is in, checking vep_SYMBOL,
+// vep_HGNC_ID and vep_Gene and, failing those, the SYMBOL, HGNC_ID
+// and Gene of the CSQ entry rankSevere picks. A pick that is only upstream,
+// downstream or intergenic does not put the variant in the gene.
+func (p *genePanel) match(v *vcfgo.Variant) *panelGene {
+	if p == nil || len(p.genes) == 0 {
+		return nil
+	}
+	for _, k := range []string{"vep_SYMBOL", "vep_HGNC_ID", "vep_Gene"} {
+		if g, ok := p.genes[normGeneID(infoFirst(v, k))]; ok {
+			return g
+		}
+	}
+	if p.csqKeys == nil {
+		return nil
+	}
+	acsq := getCSQ(v, p.csqKeys)
+	if len(acsq) == 0 {
+		return nil
+	}
+	c := rankSevere(acsq)
+	if !inGene(c["Consequence"]) {
+		return nil
+	}
+	for _, k := range []string{"SYMBOL", "HGNC_ID", "Gene"} {
+		if g, ok := p.genes[normGeneID(c[k])]; ok {
+			return g
+		}
+	}
+	return nil
+}
+
+// inGene is false when every consequence is upstream, downstream or
+// intergenic
+func inGene(consequence string) bool {
+	for _, c := range strings.Split(consequence, "&") {
+		switch c {
+		case "", "upstream_gene_variant", "downstream_gene_variant", "intergenic_variant":
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// hasRecessiveGenotype is true for variants slivar called as recessive,
+// x-linked recessive or part of a compound het
+func hasRecessiveGenotype(v *vcfgo.Variant) bool {
+	for _, k := range []string{"recessive", "x_recessive", "slivar_comphet"} {
+		if val, err := v.Info().Get(k); err == nil && val != nil {
+			return true
+		}
+	}
+	return false
+}
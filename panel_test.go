@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brentp/vcfgo"
+)
+
+func testPanel(t *testing.T) *genePanel {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "panel.tsv")
+	tsv := "#symbol\thgnc_id\tensembl_gene_id\tmoi\taliases\n" +
+		"BRCA1\tHGNC:1100\tENSG00000012048\tAD\t\n" +
+		"CFTR\tHGNC:1884\tENSG00000001626\tAR\tABCC7,CF\n"
+	if err := os.WriteFile(path, []byte(tsv), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := newGenePanel()
+	if err := p.readGenePanel(path); err != nil {
+		t.Fatal(err)
+	}
+	p.csqKeys = []string{"Allele", "Consequence", "IMPACT", "SYMBOL", "Gene", "HGNC_ID", "CANONICAL"}
+	return p
+}
+
+func TestParseMOI(t *testing.T) {
+	for moi, want := range map[string][2]bool{
+		"AD":                 {true, false},
+		"AR":                 {false, true},
+		"XLR":                {false, true},
+		"MONOALLELIC":        {true, false},
+		"BIALLELIC":          {false, true},
+		"AD, AR":             {true, true},
+		"BOTH":               {true, true},
+		"":                   {true, true},
+		"unknown":            {true, true},
+		"monoallelic; mt":    {true, false},
+		"X-linked recessive": {false, true},
+	} {
+		if d, r := parseMOI(moi); d != want[0] || r != want[1] {
+			t.Errorf("%q: got dominant %v recessive %v, want %v", moi, d, r, want)
+		}
+	}
+}
+
+func TestGenePanelMatch(t *testing.T) {
+	p := testPanel(t)
+	for _, tc := range []struct {
+		name string
+		info testInfo
+		want string
+	}{
+		{"vep_SYMBOL any case", testInfo{"vep_SYMBOL": "brca1"}, "BRCA1"},
+		{"vep_HGNC_ID without prefix", testInfo{"vep_HGNC_ID": "1884"}, "CFTR"},
+		{"vep_HGNC_ID with prefix", testInfo{"vep_HGNC_ID": "HGNC:1100"}, "BRCA1"},
+		{"vep_Gene", testInfo{"vep_Gene": "ENSG00000001626"}, "CFTR"},
+		{"alias", testInfo{"vep_SYMBOL": "ABCC7"}, "CFTR"},
+		{"other gene", testInfo{"vep_SYMBOL": "TP53"}, ""},
+		{"most severe CSQ entry", testInfo{"CSQ": []string{
+			"G|intron_variant|MODIFIER|TP53|ENSG00000141510|HGNC:11998|YES",
+			"G|missense_variant|MODERATE|CFTR|ENSG00000001626|HGNC:1884|",
+		}}, "CFTR"},
+		{"panel gene on a less severe entry", testInfo{"CSQ": []string{
+			"G|missense_variant|MODERATE|TP53|ENSG00000141510|HGNC:11998|YES",
+			"G|intron_variant|MODIFIER|CFTR|ENSG00000001626|HGNC:1884|",
+		}}, ""},
+		{"most severe entry only upstream", testInfo{"CSQ": []string{
+			"G|upstream_gene_variant|MODIFIER|BRCA1|ENSG00000012048|HGNC:1100|YES",
+		}}, ""},
+		{"CSQ entry matched on Gene", testInfo{"CSQ": []string{
+			"G|splice_region_variant&intron_variant|LOW||ENSG00000012048||",
+		}}, "BRCA1"},
+		{"vep_ fields before CSQ", testInfo{"vep_SYMBOL": "BRCA1", "CSQ": []string{
+			"G|missense_variant|MODERATE|CFTR|ENSG00000001626|HGNC:1884|",
+		}}, "BRCA1"},
+	} {
+		v := &vcfgo.Variant{Chromosome: "1", Pos: 100, Reference: "A", Alternate: []string{"G"}, Info_: tc.info}
+		var got string
+		if g := p.match(v); g != nil {
+			got = g.symbol
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestGroupOneInheritance(t *testing.T) {
+	rk := &ranker{panel: testPanel(t)}
+	for _, tc := range []struct {
+		name string
+		info testInfo
+		want bool
+	}{
+		{"dominant gene", testInfo{"vep_IMPACT": "HIGH", "vep_SYMBOL": "BRCA1"}, true},
+		{"recessive gene without a recessive call", testInfo{"vep_IMPACT": "HIGH", "vep_SYMBOL": "CFTR"}, false},
+		{"recessive gene, recessive call", testInfo{"vep_IMPACT": "HIGH", "vep_SYMBOL": "CFTR", "recessive": "fam1"}, true},
+		{"recessive gene, comp het", testInfo{"vep_IMPACT": "HIGH", "vep_SYMBOL": "CFTR", "slivar_comphet": "S1/CFTR/1/2-5-A-G"}, true},
+		{"not in the panel", testInfo{"vep_IMPACT": "HIGH", "vep_SYMBOL": "TP53"}, false},
+		{"common", testInfo{"vep_IMPACT": "HIGH", "vep_SYMBOL": "BRCA1", "eAF_popmax": 0.01}, false},
+		{"not damaging", testInfo{"vep_IMPACT": "LOW", "vep_SYMBOL": "BRCA1"}, false},
+	} {
+		v := &vcfgo.Variant{Chromosome: "1", Pos: 100, Reference: "A", Alternate: []string{"G"}, Info_: tc.info}
+		if got := rk.groupOne(v); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
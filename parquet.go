@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/vcfgo"
+	"github.com/google/subcommands"
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetColumn is one column of the export, typed from the VCF header
+type parquetColumn struct {
+	tableColumn
+	name   string
+	typ    string // Integer, Float, Flag or String
+	list   bool
+	max    bool // a single Float column keeping the largest of several values
+	sample int  // FORMAT columns in wide layout, otherwise -1
+}
+
+// node is the column's schema node. Lists are optional LISTs of optional
+// elements so a missing value keeps its position.
+func (c parquetColumn) node() parquet.Node {
+	var n parquet.Node
+	switch c.typ {
+	case "Integer":
+		n = parquet.Int(64)
+	case "Float":
+		n = parquet.Leaf(parquet.DoubleType)
+	case "Flag":
+		return parquet.Leaf(parquet.BooleanType)
+	default:
+		n = parquet.String()
+	}
+	switch {
+	case c.list:
+		return parquet.Optional(parquet.List(parquet.Optional(n)))
+	case c.required():
+		return n
+	}
+	return parquet.Optional(n)
+}
+
+func (c parquetColumn) required() bool {
+	return c.kind == "CHROM" || c.kind == "POS" || c.kind == "REF"
+}
+
+// values converts the column's values to the parquet values of leaf column
+// col. parquet-go's reflection drops the optional level of list elements,
+// so the repetition and definition levels are set here: a list element is
+// defined at 3, a missing one at 2, and a missing list or value at 0.
+func (c parquetColumn) values(vals []string, col int) ([]parquet.Value, error) {
+	items := make([]interface{}, len(vals))
+	present := 0
+	for i, s := range vals {
+		if s == "" || s == "." {
+			continue
+		}
+		var it interface{} = s
+		switch c.typ {
+		case "Integer":
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %q is not an Integer", c.name, s)
+			}
+			it = n
+		case "Float":
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %q is not a Float", c.name, s)
+			}
+			it = f
+		}
+		items[i] = it
+		present++
+	}
+	null := []parquet.Value{parquet.Value{}.Level(0, 0, col)}
+	switch {
+	case c.typ == "Flag":
+		return []parquet.Value{parquet.ValueOf(present > 0).Level(0, 0, col)}, nil
+	case present == 0:
+		return null, nil
+	case c.list:
+		out := make([]parquet.Value, len(items))
+		for i, it := range items {
+			rep := 1
+			if i == 0 {
+				rep = 0
+			}
+			if it == nil {
+				out[i] = parquet.Value{}.Level(rep, 2, col)
+			} else {
+				out[i] = parquet.ValueOf(it).Level(rep, 3, col)
+			}
+		}
+		return out, nil
+	}
+
+	def := 1
+	if c.required() {
+		def = 0
+	}
+	var val interface{}
+	for _, it := range items {
+		switch {
+		case it == nil:
+		case val == nil:
+			val = it
+		case c.typ == "String":
+			sep := ","
+			if c.kind == "CSQ" {
+				sep = "&"
+			}
+			val = strings.Join(vals, sep)
+		case c.max:
+			if it.(float64) > val.(float64) {
+				val = it
+			}
+		default:
+			return nil, fmt.Errorf("%s: %d values for a single value column", c.name, present)
+		}
+	}
+	return []parquet.Value{parquet.ValueOf(val).Level(0, def, col)}, nil
+}
+
+type toParquet struct {
+	columns  string
+	out      string
+	samples  string
+	csq      string
+	types    string
+	rowGroup int64
+}
+
+func (*toParquet) Name() string { return "toParquet" }
+func (*toParquet) Synopsis() string {
+	return "write selected vcf columns, INFO, FORMAT and CSQ fields to a typed parquet file"
+}
+func (*toParquet) Usage() string {
+	return `toParquet -columns CHROM,POS,REF,ALT,INFO/rank,FORMAT/GT,CSQ/SYMBOL -o out.parquet [-samples wide|long] [-csq severe|canonical] [-types REVEL_score:Float]
+
+Column types come from the header: Integer is int64, Float double, Flag
+boolean and anything else a string. Number=1 fields are optional values,
+other Numbers are lists, as are ALT and CSQ fields with Number . in -types.
+A missing value in a list is a null element, so Number=A, R and G values
+stay at the position of their allele.
+CSQ field types follow pullCSQ. With -samples long there is a row per
+variant and sample, with a sample column, instead of a column per sample.
+Rows are written in row groups of -row-group rows, so memory is bounded
+by the row group and not the input.
+`
+}
+
+func (p *toParquet) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.columns, "columns", "CHROM,POS,REF,ALT", "comma sep columns: CHROM, POS, ID, REF, ALT, QUAL, FILTER, INFO/key, FORMAT/key or CSQ/key")
+	f.StringVar(&p.out, "o", "-", "output parquet file, - for stdout")
+	f.StringVar(&p.samples, "samples", "wide", "FORMAT layout: wide (a column per sample, sample:key) or long (a row per sample)")
+	f.StringVar(&p.csq, "csq", "severe", "which CSQ transcript CSQ/ columns come from (severe, canonical)")
+	f.StringVar(&p.types, "types", "", "comma sep CSQ field:Type[:Number], otherwise inferred from the field name as in pullCSQ")
+	f.Int64Var(&p.rowGroup, "row-group", 100000, "rows per parquet row group")
+}
+
+// schema types the columns and lays FORMAT columns out by sample
+func (p *toParquet) schema(cols []tableColumn, h *vcfgo.Header, csqTypes map[string]csqType) ([]parquetColumn, *parquet.Schema, error) {
+	var pcols []parquetColumn
+	if p.samples == "long" {
+		pcols = append(pcols, parquetColumn{tableColumn: tableColumn{kind: "SAMPLE"}, name: "sample", typ: "String", sample: -1})
+	}
+	for _, c := range cols {
+		pc := parquetColumn{tableColumn: c, name: c.kind, typ: "String", sample: -1}
+		switch c.kind {
+		case "POS":
+			pc.typ = "Integer"
+		case "QUAL":
+			pc.typ = "Float"
+		case "ALT":
+			pc.list = true
+		case "INFO":
+			pc.name = c.key
+			if info, ok := h.Infos[c.key]; ok {
+				pc.typ = info.Type
+			}
+			pc.list = c.number != "1" && c.number != "0"
+		case "FORMAT":
+			pc.name = c.key
+			if sf, ok := h.SampleFormats[c.key]; ok {
+				pc.typ = sf.Type
+			}
+			pc.list = c.number != "1"
+		case "CSQ":
+			t, ok := csqTypes[c.key]
+			if !ok {
+				t = inferCSQType(c.key)
+			}
+			pc.name, pc.typ, pc.list, pc.max = c.key, t.typ, t.number != "1", t.max && t.typ == "Float"
+		}
+		if pc.kind == "FORMAT" && p.samples == "wide" {
+			for i, s := range h.SampleNames {
+				sc := pc
+				sc.name, sc.sample = s+":"+c.key, i
+				pcols = append(pcols, sc)
+			}
+			continue
+		}
+		pcols = append(pcols, pc)
+	}
+
+	group := parquet.Group{}
+	for _, c := range pcols {
+		if _, ok := group[c.name]; ok {
+			return nil, nil, fmt.Errorf("two columns are named %s", c.name)
+		}
+		group[c.name] = c.node()
+	}
+	return pcols, parquet.NewSchema("variant", group), nil
+}
+
+func (p *toParquet) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := statsFrom(args)
+	ep := errPolicyFrom(args)
+
+	switch p.samples {
+	case "wide", "long":
+	default:
+		return ep.usage(fmt.Sprintf("unknown -samples %q, expected wide or long", p.samples))
+	}
+	switch p.csq {
+	case "severe", "canonical":
+	default:
+		return ep.usage(fmt.Sprintf("unknown -csq %q, expected severe or canonical", p.csq))
+	}
+	if p.rowGroup < 1 {
+		return ep.usage("-row-group must be at least 1")
+	}
+	csqTypes, err := parseCSQTypes(p.types)
+	if err != nil {
+		return ep.usage(err.Error())
+	}
+
+	rdr, err := vcfgo.NewReader(os.Stdin, false)
+	if err != nil {
+		return ep.fatal(err)
+	}
+	cols, err := parseColumns(p.columns, rdr.Header)
+	if err != nil {
+		return ep.usage(err.Error())
+	}
+	pcols, schema, err := p.schema(cols, rdr.Header, csqTypes)
+	if err != nil {
+		return ep.usage(err.Error())
+	}
+
+	var csqKeys []string
+	for _, c := range cols {
+		if c.kind == "CSQ" {
+			csqKeys, err = getCSQKeys(rdr.Header)
+			if err != nil {
+				return ep.fatal(err)
+			}
+			break
+		}
+	}
+
+	samples := []int{-1}
+	if p.samples == "long" && len(rdr.Header.SampleNames) > 0 {
+		samples = samples[:0]
+		for i := range rdr.Header.SampleNames {
+			samples = append(samples, i)
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	if p.out != "-" {
+		file, err := os.Create(p.out)
+		if err != nil {
+			return ep.fatal(err)
+		}
+		defer file.Close()
+		out = file
+	}
+	wrt := parquet.NewWriter(out, schema, parquet.MaxRowsPerRowGroup(p.rowGroup))
+	leaf := map[string]int{}
+	for i, path := range schema.Columns() {
+		leaf[path[0]] = i
+	}
+	// rows are written in leaf column order
+	sort.Slice(pcols, func(i, j int) bool { return leaf[pcols[i].name] < leaf[pcols[j].name] })
+
+	for {
+		variant := rdr.Read()
+		if variant == nil {
+			break
+		}
+		st.RecordsIn++
+		if err := readErr(rdr); err != nil {
+			if !ep.record(variantWhere(variant), err) {
+				wrt.Close()
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+
+		var csq map[string]string
+		if csqKeys != nil {
+			if acsq := getCSQ(variant, csqKeys); len(acsq) > 0 {
+				if p.csq == "canonical" {
+					csq = rankCanon(acsq)
+				} else {
+					csq = rankSevere(acsq)
+				}
+			}
+		}
+
+		var rows []parquet.Row
+		var bad error
+		for _, si := range samples {
+			var row parquet.Row
+			for _, c := range pcols {
+				var vals []string
+				switch c.kind {
+				case "SAMPLE":
+					if si >= 0 {
+						vals = []string{rdr.Header.SampleNames[si]}
+					}
+				case "CHROM":
+					vals = []string{variant.Chromosome}
+				case "POS":
+					vals = []string{strconv.FormatUint(variant.Pos, 10)}
+				case "ID":
+					vals = []string{variant.Id_}
+				case "REF":
+					vals = []string{variant.Reference}
+				case "ALT":
+					vals = variant.Alternate
+				case "QUAL":
+					vals = []string{strconv.FormatFloat(float64(variant.Quality), 'g', -1, 32)}
+				case "FILTER":
+					vals = []string{variant.Filter}
+				case "INFO":
+					val, err := variant.Info().Get(c.key)
+					if err == nil {
+						vals = infoStrings(val)
+					}
+				case "FORMAT":
+					i := c.sample
+					if i < 0 {
+						i = si
+					}
+					if i >= 0 && i < len(variant.Samples) && variant.Samples[i] != nil {
+						if v, ok := sampleField(variant.Samples[i], c.key); ok {
+							vals = strings.Split(v, ",")
+						}
+					}
+				case "CSQ":
+					if v := csq[c.key]; v != "" {
+						vals = strings.Split(v, "&")
+					}
+				}
+				pv, err := c.values(vals, leaf[c.name])
+				if err != nil {
+					bad = err
+					continue
+				}
+				row = append(row, pv...)
+			}
+			rows = append(rows, row)
+		}
+		if bad != nil {
+			if !ep.record(variantWhere(variant), bad) {
+				wrt.Close()
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+
+		if _, err := wrt.WriteRows(rows); err != nil {
+			return ep.fatal(err)
+		}
+		st.RecordsOut += len(rows)
+	}
+	if err := wrt.Close(); err != nil {
+		return ep.fatal(err)
+	}
+	return subcommands.ExitSuccess
+}
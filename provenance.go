@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brentp/vcfgo"
+	"github.com/google/subcommands"
+)
+
+// version is set at build time with -ldflags "-X main.version=v1.2.3", and
+// otherwise taken from the module version go install records
+var version = ""
+
+func toolVersion() string {
+	if version != "" {
+		return version
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" {
+		return bi.Main.Version
+	}
+	return "(devel)"
+}
+
+// addProvenance records the version, arguments and time of this run in the
+// header the way bcftools does:
+//
+//	##vcfUtils_rankVersion=v1.2.3
+//	##vcfUtils_rankCommand=rank -explain -genes genes.txt; Date=Mon Jan  2 15:04:05 2006
+func addProvenance(h *vcfgo.Header, cmd string) {
+	vline := fmt.Sprintf("##vcfUtils_%sVersion=%s", cmd, toolVersion())
+	seen := false
+	for _, e := range h.Extras {
+		seen = seen || e == vline
+	}
+	if !seen {
+		h.Extras = append(h.Extras, vline)
+	}
+
+	args := make([]string, 0, len(os.Args))
+	for _, a := range os.Args[1:] {
+		if a == "" || strings.ContainsAny(a, " \t;\"'") {
+			a = strconv.Quote(a)
+		}
+		args = append(args, a)
+	}
+	h.Extras = append(h.Extras, fmt.Sprintf("##vcfUtils_%sCommand=%s; Date=%s", cmd, strings.Join(args, " "), time.Now().Format(time.ANSIC)))
+}
+
+type provenance struct{}
+
+func (*provenance) Name() string { return "provenance" }
+func (*provenance) Synopsis() string {
+	return "print the processing history recorded in a vcf header"
+}
+func (*provenance) Usage() string {
+	return `provenance [in.vcf[.gz]] < in.vcf
+
+Prints one line per ##<tool>_<command>Command= header line, oldest first:
+tool, command, version, date and arguments, tab separated. This covers the
+lines vcfUtils and bcftools write.
+`
+}
+
+func (*provenance) SetFlags(f *flag.FlagSet) {}
+
+var provenanceRe = regexp.MustCompile(`^##([A-Za-z0-9]+)_([A-Za-z0-9]+)(Command|Version)=(.*)$`)
+
+func (*provenance) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := statsFrom(args)
+	ep := errPolicyFrom(args)
+
+	var in io.Reader = os.Stdin
+	if f.NArg() > 0 {
+		rc, err := openMaybeGzip(f.Arg(0))
+		if err != nil {
+			return ep.fatal(err)
+		}
+		defer rc.Close()
+		in = rc
+	}
+
+	versions := map[string]string{}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	fmt.Println("#tool\tcommand\tversion\tdate\targuments")
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "##") {
+			break
+		}
+		m := provenanceRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		tool, cmd, val := m[1], m[2], m[4]
+		if m[3] == "Version" {
+			versions[tool+"_"+cmd] = val
+			continue
+		}
+		st.RecordsIn++
+
+		date := "."
+		if i := strings.LastIndex(val, "; Date="); i >= 0 {
+			val, date = val[:i], val[i+len("; Date="):]
+		}
+		v := versions[tool+"_"+cmd]
+		if v == "" {
+			v = "."
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\n", tool, cmd, v, date, val)
+		st.RecordsOut++
+	}
+	if err := scanner.Err(); err != nil {
+		return ep.fatal(err)
+	}
+	return subcommands.ExitSuccess
+}
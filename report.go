@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/vcfgo"
+	"github.com/google/subcommands"
+	"github.com/xuri/excelize/v2"
+)
+
+type reportVariant struct {
+	id       string
+	chrom    string
+	pos      int
+	ref      string
+	alt      string
+	gene     string
+	csq      string
+	rank     float64
+	chetRank float64
+	pairs    []string // slivar_comphet pair ids
+	fields   []string
+	csqs     []map[string]string
+}
+
+type reportRow struct {
+	v        *reportVariant
+	pair     string
+	partners []*reportVariant
+}
+
+// compHetRows lists the comp-het variants in rows so the members of each
+// pair are on adjacent rows. A variant in several pairs appears once per
+// pair. Pairs are built from all variants, so a partner in another
+// comphet tier is still listed.
+func compHetRows(rows, all []*reportVariant) []reportRow {
+	members := map[string][]*reportVariant{}
+	for _, v := range all {
+		for _, p := range v.pairs {
+			members[p] = append(members[p], v)
+		}
+	}
+
+	inRows := map[*reportVariant]bool{}
+	var order []string
+	seen := map[string]bool{}
+	for _, v := range rows {
+		inRows[v] = true
+		for _, p := range v.pairs {
+			if !seen[p] {
+				seen[p] = true
+				order = append(order, p)
+			}
+		}
+	}
+
+	var out []reportRow
+	for _, p := range order {
+		for _, v := range members[p] {
+			if !inRows[v] {
+				continue
+			}
+			var partners []*reportVariant
+			for _, o := range members[p] {
+				if o != v {
+					partners = append(partners, o)
+				}
+			}
+			out = append(out, reportRow{v, p, partners})
+		}
+	}
+	return out
+}
+
+// partnerName is the partner's id, with its comphet tier when that is not
+// the tier of the row
+func partnerName(o reportRow, p *reportVariant) string {
+	if p.chetRank != o.v.chetRank {
+		return p.id + " (comphet tier " + tierName(p.chetRank) + ")"
+	}
+	return p.id
+}
+
+// groupTiers splits variants by rank and by comphet_rank
+func groupTiers(variants []*reportVariant) (map[float64][]*reportVariant, map[float64][]*reportVariant) {
+	tiers := map[float64][]*reportVariant{}
+	chetTiers := map[float64][]*reportVariant{}
+	for _, v := range variants {
+		if v.rank != 0 {
+			tiers[v.rank] = append(tiers[v.rank], v)
+		}
+		if v.chetRank != 0 {
+			chetTiers[v.chetRank] = append(chetTiers[v.chetRank], v)
+		}
+	}
+	return tiers, chetTiers
+}
+
+// compHetPairIDs returns the pair ids from slivar_comphet, the third / field
+func compHetPairIDs(v *vcfgo.Variant) []string {
+	chI, err := v.Info().Get("slivar_comphet")
+	if err != nil {
+		return nil
+	}
+	var ids []string
+	for _, ch := range infoStrings(chI) {
+		if s := strings.Split(ch, "/"); len(s) > 2 {
+			ids = append(ids, s[2])
+		}
+	}
+	return ids
+}
+
+func gnomadURL(build string, r *reportVariant) string {
+	dataset := "gnomad_r4"
+	if build == "GRCh37" {
+		dataset = "gnomad_r2_1"
+	}
+	return "https://gnomad.broadinstitute.org/variant/" + gnomadVarID.key(r.chrom, r.pos, r.ref, r.alt) + "?dataset=" + dataset
+}
+
+func clinvarURL(build string, r *reportVariant) string {
+	chrpos := "chrpos38"
+	if build == "GRCh37" {
+		chrpos = "chrpos37"
+	}
+	term := fmt.Sprintf("%s[chr] AND %d[%s]", strings.TrimPrefix(r.chrom, "chr"), r.pos, chrpos)
+	return "https://www.ncbi.nlm.nih.gov/clinvar/?term=" + url.QueryEscape(term)
+}
+
+func tierName(r float64) string {
+	return strconv.FormatFloat(r, 'g', -1, 64)
+}
+
+type report struct {
+	out       string
+	format    string
+	fields    string
+	csqFields string
+	build     string
+}
+
+func (*report) Name() string { return "report" }
+func (*report) Synopsis() string {
+	return "write variants with rank or comphet_rank to an xlsx workbook or html page, one sheet/table per tier"
+}
+func (*report) Usage() string {
+	return `report -out review.xlsx|review.html [-fields CADD_phred,REVEL_score] [-build GRCh38] < ranked.vcf`
+}
+
+func (r *report) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&r.out, "out", "", "output file (.xlsx or .html)")
+	f.StringVar(&r.format, "format", "", "output format (xlsx, html), default from -out extension")
+	f.StringVar(&r.fields, "fields", "vep_IMPACT,CADD_phred,REVEL_score,spliceAI_max,eAF_popmax,gAF_popmax,TOPMed_AF,gnomAD_pLI,phom,pchet", "comma sep INFO fields to include")
+	f.StringVar(&r.csqFields, "csq-fields", "Feature,SYMBOL,Consequence,IMPACT,HGVSc,HGVSp,BIOTYPE,CANONICAL,APPRIS,TSL", "comma sep CSQ fields shown per transcript in the html report")
+	f.StringVar(&r.build, "build", "GRCh38", "genome build used for gnomAD and ClinVar links (GRCh37, GRCh38)")
+}
+
+func (r *report) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := statsFrom(args)
+	ep := errPolicyFrom(args)
+	if r.out == "" {
+		return ep.usage("-out is required")
+	}
+
+	format := r.format
+	if format == "" {
+		format = "xlsx"
+		if strings.HasSuffix(r.out, ".html") || strings.HasSuffix(r.out, ".htm") {
+			format = "html"
+		}
+	}
+	if format != "xlsx" && format != "html" {
+		return ep.usage(fmt.Sprintf("unknown format %q, expected xlsx or html", format))
+	}
+
+	rdr, err := vcfgo.NewReader(os.Stdin, false)
+	if err != nil {
+		return ep.fatal(err)
+	}
+
+	// CSQ details are only shown in the html report and are optional
+	var csqKeys []string
+	if format == "html" {
+		csqKeys, _ = getCSQKeys(rdr.Header)
+	}
+
+	fields := strings.Split(r.fields, ",")
+	var variants []*reportVariant
+	for {
+		variant := rdr.Read()
+		if variant == nil {
+			break
+		}
+		st.RecordsIn++
+		if err := readErr(rdr); err != nil {
+			if !ep.record(variantWhere(variant), err) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+
+		rv := &reportVariant{
+			id:       getVarID(variant),
+			chrom:    variant.Chromosome,
+			pos:      int(variant.Pos),
+			ref:      variant.Reference,
+			alt:      variant.Alternate[0],
+			gene:     infoFirst(variant, "vep_SYMBOL", "SYMBOL"),
+			csq:      infoFirst(variant, "vep_Consequence", "Consequence"),
+			rank:     infoFloat(variant, "rank"),
+			chetRank: infoFloat(variant, "comphet_rank"),
+		}
+		if rv.rank == 0 && rv.chetRank == 0 {
+			continue
+		}
+		if rv.chetRank != 0 {
+			rv.pairs = compHetPairIDs(variant)
+		}
+		for _, k := range fields {
+			rv.fields = append(rv.fields, infoFirst(variant, k))
+		}
+		if csqKeys != nil {
+			rv.csqs = getCSQ(variant, csqKeys)
+		}
+		variants = append(variants, rv)
+		st.RecordsOut++
+		if rv.rank != 0 {
+			st.count("tier " + tierName(rv.rank))
+		}
+		if rv.chetRank != 0 {
+			st.count("comphet tier " + tierName(rv.chetRank))
+		}
+	}
+
+	if format == "html" {
+		err = r.writeHTML(variants, fields)
+	} else {
+		err = r.writeXLSX(variants, fields)
+	}
+	if err != nil {
+		return ep.fatal(err)
+	}
+	return subcommands.ExitSuccess
+}
+
+// writeXLSX writes a sheet per rank and per comphet_rank. Comp-het sheets list
+// the variants of each pair on adjacent rows.
+func (r *report) writeXLSX(variants []*reportVariant, fields []string) error {
+	xl := excelize.NewFile()
+	defer xl.Close()
+
+	bold, err := xl.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	link, err := xl.NewStyle(&excelize.Style{Font: &excelize.Font{Color: "0563C1", Underline: "single"}})
+	if err != nil {
+		return err
+	}
+
+	tiers, chetTiers := groupTiers(variants)
+
+	writeSheet := func(name string, rows []*reportVariant, comphet bool) error {
+		if _, err := xl.NewSheet(name); err != nil {
+			return err
+		}
+
+		header := []interface{}{"variant", "gene", "consequence", "rank", "comphet_rank"}
+		if comphet {
+			header = append(header, "pair", "partner")
+		}
+		for _, k := range fields {
+			header = append(header, k)
+		}
+		header = append(header, "gnomAD", "ClinVar")
+		if err := xl.SetSheetRow(name, "A1", &header); err != nil {
+			return err
+		}
+		if err := xl.SetRowStyle(name, 1, 1, bold); err != nil {
+			return err
+		}
+
+		var out []reportRow
+		if comphet {
+			out = compHetRows(rows, variants)
+		} else {
+			for _, v := range rows {
+				out = append(out, reportRow{v: v})
+			}
+		}
+
+		for i, o := range out {
+			vals := []interface{}{o.v.id, o.v.gene, o.v.csq, rankCell(o.v.rank), rankCell(o.v.chetRank)}
+			if comphet {
+				var partners []string
+				for _, p := range o.partners {
+					partners = append(partners, partnerName(o, p))
+				}
+				vals = append(vals, o.pair, strings.Join(partners, ","))
+			}
+			for _, fv := range o.v.fields {
+				vals = append(vals, cellValue(fv))
+			}
+			vals = append(vals, "gnomAD", "ClinVar")
+
+			cell, _ := excelize.CoordinatesToCellName(1, i+2)
+			if err := xl.SetSheetRow(name, cell, &vals); err != nil {
+				return err
+			}
+			for j, u := range []string{gnomadURL(r.build, o.v), clinvarURL(r.build, o.v)} {
+				cell, _ := excelize.CoordinatesToCellName(len(vals)-1+j, i+2)
+				if err := xl.SetCellHyperLink(name, cell, u, "External"); err != nil {
+					return err
+				}
+				if err := xl.SetCellStyle(name, cell, cell, link); err != nil {
+					return err
+				}
+			}
+		}
+
+		last, _ := excelize.CoordinatesToCellName(len(header), len(out)+1)
+		if err := xl.AutoFilter(name, "A1:"+last, nil); err != nil {
+			return err
+		}
+		return xl.SetPanes(name, &excelize.Panes{
+			Freeze:      true,
+			YSplit:      1,
+			TopLeftCell: "A2",
+			ActivePane:  "bottomLeft",
+		})
+	}
+
+	for _, t := range sortedTiers(tiers) {
+		if err := writeSheet("tier "+tierName(t), tiers[t], false); err != nil {
+			return err
+		}
+	}
+	for _, t := range sortedTiers(chetTiers) {
+		if err := writeSheet("comphet tier "+tierName(t), chetTiers[t], true); err != nil {
+			return err
+		}
+	}
+
+	if len(tiers)+len(chetTiers) > 0 {
+		if err := xl.DeleteSheet("Sheet1"); err != nil {
+			return err
+		}
+		xl.SetActiveSheet(0)
+	}
+	return xl.SaveAs(r.out)
+}
+
+func sortedTiers(m map[float64][]*reportVariant) []float64 {
+	var ts []float64
+	for t := range m {
+		ts = append(ts, t)
+	}
+	sort.Float64s(ts)
+	return ts
+}
+
+func rankCell(r float64) interface{} {
+	if r == 0 {
+		return ""
+	}
+	return r
+}
+
+// cellValue stores numbers as numbers so they sort and filter in Excel
+func cellValue(s string) interface{} {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
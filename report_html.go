@@ -0,0 +1,256 @@
+package main
+
+import (
+	"html/template"
+	"os"
+	"sort"
+	"strings"
+)
+
+type htmlLink struct {
+	Href string
+	Text string
+}
+
+type htmlCSQ struct {
+	Values    []string
+	Severe    bool
+	Canonical bool
+}
+
+type htmlRow struct {
+	Anchor      string
+	Variant     string
+	Gene        string
+	Consequence string
+	Rank        string
+	CompHetRank string
+	Pair        string
+	Partners    []htmlLink
+	Fields      []string
+	GnomAD      string
+	ClinVar     string
+	CSQ         []htmlCSQ
+}
+
+type htmlSection struct {
+	ID      string
+	Title   string
+	CompHet bool
+	Rows    []htmlRow
+}
+
+type htmlGene struct {
+	Name  string
+	Links []htmlLink
+}
+
+type htmlReport struct {
+	Fields    []string
+	CSQFields []string
+	Sections  []htmlSection
+	Genes     []htmlGene
+}
+
+// htmlAnchor is a row's id. Comp-het variants have a row per pair, so
+// their anchors include the pair id.
+func htmlAnchor(section, pair string, v *reportVariant) string {
+	if pair != "" {
+		return section + "-" + pair + "-" + v.id
+	}
+	return section + "-" + v.id
+}
+
+// writeHTML writes a single self-contained page with a sortable, filterable
+// table per tier, an index of variants by gene and the CSQ entries of each
+// variant, marking the transcripts pullCSQ would pick.
+func (r *report) writeHTML(variants []*reportVariant, fields []string) error {
+	csqFields := strings.Split(r.csqFields, ",")
+	data := htmlReport{Fields: fields, CSQFields: csqFields}
+
+	rankText := func(x float64) string {
+		if x == 0 {
+			return ""
+		}
+		return tierName(x)
+	}
+
+	genes := map[string][]htmlLink{}
+	addSection := func(id, title string, comphet bool, rows []reportRow) {
+		sec := htmlSection{ID: id, Title: title, CompHet: comphet}
+		for _, o := range rows {
+			v := o.v
+			row := htmlRow{
+				Anchor:      htmlAnchor(id, o.pair, v),
+				Variant:     v.id,
+				Gene:        v.gene,
+				Consequence: v.csq,
+				Rank:        rankText(v.rank),
+				CompHetRank: rankText(v.chetRank),
+				Pair:        o.pair,
+				Fields:      v.fields,
+				GnomAD:      gnomadURL(r.build, v),
+				ClinVar:     clinvarURL(r.build, v),
+			}
+			for _, p := range o.partners {
+				row.Partners = append(row.Partners, htmlLink{Href: "#" + htmlAnchor("comphet-"+tierName(p.chetRank), o.pair, p), Text: partnerName(o, p)})
+			}
+
+			severe, canon := csqChoice(v.csqs)
+			for i, c := range v.csqs {
+				hc := htmlCSQ{Severe: i == severe, Canonical: i == canon}
+				for _, k := range csqFields {
+					hc.Values = append(hc.Values, c[k])
+				}
+				row.CSQ = append(row.CSQ, hc)
+			}
+
+			gene := v.gene
+			if gene == "" {
+				gene = "(no gene)"
+			}
+			genes[gene] = append(genes[gene], htmlLink{Href: "#" + row.Anchor, Text: title + ": " + v.id})
+			sec.Rows = append(sec.Rows, row)
+		}
+		data.Sections = append(data.Sections, sec)
+	}
+
+	tiers, chetTiers := groupTiers(variants)
+	for _, t := range sortedTiers(tiers) {
+		var rows []reportRow
+		for _, v := range tiers[t] {
+			rows = append(rows, reportRow{v: v})
+		}
+		addSection("tier-"+tierName(t), "tier "+tierName(t), false, rows)
+	}
+	for _, t := range sortedTiers(chetTiers) {
+		addSection("comphet-"+tierName(t), "comphet tier "+tierName(t), true, compHetRows(chetTiers[t], variants))
+	}
+
+	for g, links := range genes {
+		data.Genes = append(data.Genes, htmlGene{Name: g, Links: links})
+	}
+	sort.Slice(data.Genes, func(i, j int) bool { return data.Genes[i].Name < data.Genes[j].Name })
+
+	file, err := os.Create(r.out)
+	if err != nil {
+		return err
+	}
+	if err := reportTemplate.Execute(file, data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>vcfUtils report</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; margin: 1em 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 2px 6px; text-align: left; vertical-align: top; }
+table.sortable > thead th { background: #eee; cursor: pointer; position: sticky; top: 0; }
+table.sortable > thead th[data-dir=asc]::after { content: " \25B2"; }
+table.sortable > thead th[data-dir=desc]::after { content: " \25BC"; }
+tr:target { background: #ffef9e; }
+tr.pair-start > td { border-top: 2px solid #666; }
+table.csq td { font-size: 12px; }
+tr.severe { font-weight: bold; }
+tr.canonical > td:first-child::before { content: "\2605 "; }
+input.filter { margin: 0.5em 0; width: 20em; }
+</style>
+</head>
+<body>
+<h1>Ranked variants</h1>
+<p>Bold transcripts are the most severe, starred the canonical pick, as chosen by pullCSQ.</p>
+<ul>
+{{- range .Sections}}
+<li><a href="#{{.ID}}">{{.Title}}</a> ({{len .Rows}})</li>
+{{- end}}
+<li><a href="#genes">by gene</a></li>
+</ul>
+
+{{- $fields := .Fields}}
+{{- $csqFields := .CSQFields}}
+{{- range .Sections}}
+<h2 id="{{.ID}}">{{.Title}}</h2>
+<input class="filter" data-table="table-{{.ID}}" placeholder="filter {{.Title}}">
+<table class="sortable" id="table-{{.ID}}">
+<thead><tr>
+<th>variant</th><th>gene</th><th>consequence</th><th>rank</th><th>comphet_rank</th>
+{{- if .CompHet}}<th>pair</th><th>partner</th>{{end}}
+{{- range $fields}}<th>{{.}}</th>{{end}}
+<th>links</th><th data-nosort>CSQ</th>
+</tr></thead>
+<tbody>
+{{- $comphet := .CompHet}}
+{{- $pair := ""}}
+{{- range .Rows}}
+<tr id="{{.Anchor}}"{{if and $comphet (ne .Pair $pair)}} class="pair-start"{{end}}>
+{{- $pair = .Pair}}
+<td>{{.Variant}}</td><td>{{.Gene}}</td><td>{{.Consequence}}</td><td>{{.Rank}}</td><td>{{.CompHetRank}}</td>
+{{- if $comphet}}<td>{{.Pair}}</td><td>{{range .Partners}}<a href="{{.Href}}">{{.Text}}</a> {{end}}</td>{{end}}
+{{- range .Fields}}<td>{{.}}</td>{{end}}
+<td><a href="{{.GnomAD}}">gnomAD</a> <a href="{{.ClinVar}}">ClinVar</a></td>
+<td>{{if .CSQ}}<details><summary>{{len .CSQ}} transcripts</summary>
+<table class="csq"><tr>{{range $csqFields}}<th>{{.}}</th>{{end}}</tr>
+{{- range .CSQ}}
+<tr class="{{if .Severe}}severe{{end}}{{if .Canonical}} canonical{{end}}">{{range .Values}}<td>{{.}}</td>{{end}}</tr>
+{{- end}}
+</table></details>{{end}}</td>
+</tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+
+<h2 id="genes">By gene</h2>
+<table>
+{{- range .Genes}}
+<tr><td>{{.Name}}</td><td>{{range .Links}}<a href="{{.Href}}">{{.Text}}</a><br>{{end}}</td></tr>
+{{- end}}
+</table>
+
+<script>
+document.querySelectorAll("table.sortable").forEach(function (table) {
+  var tbody = table.tBodies[0];
+  Array.prototype.forEach.call(table.tHead.rows[0].cells, function (th, col) {
+    if (th.hasAttribute("data-nosort")) {
+      return;
+    }
+    th.addEventListener("click", function () {
+      var asc = th.getAttribute("data-dir") !== "asc";
+      Array.prototype.forEach.call(table.tHead.rows[0].cells, function (o) {
+        o.removeAttribute("data-dir");
+      });
+      th.setAttribute("data-dir", asc ? "asc" : "desc");
+      var rows = Array.prototype.slice.call(tbody.rows);
+      rows.sort(function (a, b) {
+        var x = a.cells[col].textContent.trim(), y = b.cells[col].textContent.trim();
+        var nx = parseFloat(x), ny = parseFloat(y);
+        var c = !isNaN(nx) && !isNaN(ny) ? nx - ny : x.localeCompare(y);
+        return asc ? c : -c;
+      });
+      rows.forEach(function (r) {
+        r.classList.remove("pair-start");
+        tbody.appendChild(r);
+      });
+    });
+  });
+});
+document.querySelectorAll("input.filter").forEach(function (input) {
+  var tbody = document.getElementById(input.getAttribute("data-table")).tBodies[0];
+  input.addEventListener("input", function () {
+    var q = input.value.toLowerCase();
+    Array.prototype.forEach.call(tbody.rows, function (r) {
+      r.style.display = r.textContent.toLowerCase().indexOf(q) >= 0 ? "" : "none";
+    });
+  });
+});
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// testReportVariants has pair P1 split across comphet tiers 1 and 2, and
+// pair P2 within comphet tier 1
+func testReportVariants() []*reportVariant {
+	mk := func(id string, rank, chetRank float64, pairs ...string) *reportVariant {
+		return &reportVariant{id: id, chrom: "chr1", pos: 100, ref: "A", alt: "G", gene: "GENE", csq: "missense_variant",
+			rank: rank, chetRank: chetRank, pairs: pairs, fields: []string{"25"}}
+	}
+	return []*reportVariant{
+		mk("a", 1, 1, "P1"),
+		mk("d", 0, 1, "P2"),
+		mk("b", 0, 2, "P1"),
+		mk("c", 2, 0),
+		mk("e", 0, 1, "P2"),
+	}
+}
+
+func TestGroupTiers(t *testing.T) {
+	vs := testReportVariants()
+	tiers, chetTiers := groupTiers(vs)
+	ids := func(m map[float64][]*reportVariant) map[float64]string {
+		out := map[float64]string{}
+		for k, v := range m {
+			for _, x := range v {
+				out[k] += x.id
+			}
+		}
+		return out
+	}
+	if got, want := ids(tiers), map[float64]string{1: "a", 2: "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tiers = %v, want %v", got, want)
+	}
+	if got, want := ids(chetTiers), map[float64]string{1: "ade", 2: "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("comphet tiers = %v, want %v", got, want)
+	}
+	if got := sortedTiers(chetTiers); !reflect.DeepEqual(got, []float64{1, 2}) {
+		t.Errorf("sortedTiers = %v", got)
+	}
+}
+
+func TestCompHetRows(t *testing.T) {
+	vs := testReportVariants()
+	_, chetTiers := groupTiers(vs)
+
+	rowText := func(rows []reportRow) []string {
+		var out []string
+		for _, o := range rows {
+			var partners []string
+			for _, p := range o.partners {
+				partners = append(partners, partnerName(o, p))
+			}
+			out = append(out, o.pair+" "+o.v.id+" "+strings.Join(partners, ","))
+		}
+		return out
+	}
+
+	// the partner of a is in tier 2 but still listed, with its tier, and
+	// the members of P2 are adjacent even though a P1 row comes between
+	// them in the input
+	want := []string{"P1 a b (comphet tier 2)", "P2 d e", "P2 e d"}
+	if got := rowText(compHetRows(chetTiers[1], vs)); !reflect.DeepEqual(got, want) {
+		t.Errorf("tier 1 rows = %q, want %q", got, want)
+	}
+	want = []string{"P1 b a (comphet tier 1)"}
+	if got := rowText(compHetRows(chetTiers[2], vs)); !reflect.DeepEqual(got, want) {
+		t.Errorf("tier 2 rows = %q, want %q", got, want)
+	}
+}
+
+func TestHTMLAnchor(t *testing.T) {
+	v := &reportVariant{id: "chr1:100:A:G"}
+	if got, want := htmlAnchor("tier-1", "", v), "tier-1-chr1:100:A:G"; got != want {
+		t.Errorf("htmlAnchor = %q, want %q", got, want)
+	}
+	if got, want := htmlAnchor("comphet-1", "P1", v), "comphet-1-P1-chr1:100:A:G"; got != want {
+		t.Errorf("htmlAnchor with pair = %q, want %q", got, want)
+	}
+}
+
+func TestWriteXLSX(t *testing.T) {
+	r := &report{out: filepath.Join(t.TempDir(), "review.xlsx"), build: "GRCh38"}
+	if err := r.writeXLSX(testReportVariants(), []string{"CADD_phred"}); err != nil {
+		t.Fatal(err)
+	}
+	xl, err := excelize.OpenFile(r.out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer xl.Close()
+
+	want := []string{"tier 1", "tier 2", "comphet tier 1", "comphet tier 2"}
+	if got := xl.GetSheetList(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("sheets = %q, want %q", got, want)
+	}
+
+	rows, err := xl.GetRows("tier 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRows := [][]string{
+		{"variant", "gene", "consequence", "rank", "comphet_rank", "CADD_phred", "gnomAD", "ClinVar"},
+		{"c", "GENE", "missense_variant", "2", "", "25", "gnomAD", "ClinVar"},
+	}
+	if !reflect.DeepEqual(rows, wantRows) {
+		t.Errorf("tier 2 rows = %q, want %q", rows, wantRows)
+	}
+
+	rows, err = xl.GetRows("comphet tier 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, row := range rows {
+		got = append(got, strings.Join(row[:7], "|"))
+	}
+	want = []string{
+		"variant|gene|consequence|rank|comphet_rank|pair|partner",
+		"a|GENE|missense_variant|1|1|P1|b (comphet tier 2)",
+		"d|GENE|missense_variant||1|P2|e",
+		"e|GENE|missense_variant||1|P2|d",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("comphet tier 1 rows = %q, want %q", got, want)
+	}
+
+	link, target, err := xl.GetCellHyperLink("tier 2", "G2")
+	if err != nil || !link || !strings.HasPrefix(target, "https://gnomad.broadinstitute.org/variant/1-100-A-G") {
+		t.Errorf("gnomAD link = %v %q %v", link, target, err)
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	r := &report{out: filepath.Join(t.TempDir(), "review.html"), build: "GRCh38", csqFields: "SYMBOL"}
+	if err := r.writeHTML(testReportVariants(), []string{"CADD_phred"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(r.out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(b)
+	for _, want := range []string{
+		`<h2 id="tier-1">tier 1</h2>`,
+		`<h2 id="comphet-2">comphet tier 2</h2>`,
+		`<tr id="tier-2-c">`,
+		`<tr id="comphet-1-P1-a" class="pair-start">`,
+		`<tr id="comphet-2-P1-b" class="pair-start">`,
+		// partners link to the row of the pair in the partner's tier
+		`<a href="#comphet-2-P1-b">b (comphet tier 2)</a>`,
+		`<a href="#comphet-1-P1-a">a (comphet tier 1)</a>`,
+		`<a href="#comphet-1-P2-e">e</a>`,
+		`<a href="#tier-2-c">tier 2: c</a>`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("html report has no %s", want)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/brentp/vcfgo"
+	"github.com/dop251/goja"
+	"github.com/google/subcommands"
+)
+
+// jsVariant is the variant object handed to annotate(v). Fields are copies,
+// changes go through set and setFilter.
+type jsVariant struct {
+	v       *vcfgo.Variant
+	csqKeys []string
+	samples map[string]int
+	infos   *infoFields
+
+	Chrom  string   `json:"chrom"`
+	Pos    int      `json:"pos"`
+	ID     string   `json:"id"`
+	Ref    string   `json:"ref"`
+	Alt    []string `json:"alt"`
+	Qual   float64  `json:"qual"`
+	Filter string   `json:"filter"`
+}
+
+func (j *jsVariant) Info(key string) interface{} {
+	val, err := j.v.Info().Get(key)
+	if err != nil {
+		return nil
+	}
+	return val
+}
+
+func (j *jsVariant) Set(key string, val interface{}) error {
+	switch v := val.(type) {
+	case int64:
+		val = int(v)
+	case []interface{}:
+		val = strings.Join(infoStrings(v), ",")
+	}
+	if id := j.infos.id(key); id != "" {
+		key = id
+	}
+	return j.v.Info().Set(key, val)
+}
+
+func (j *jsVariant) SetFilter(name string) {
+	addFilter(j.v, name)
+	j.Filter = j.v.Filter
+}
+
+func (j *jsVariant) sample(name string) (int, error) {
+	i, ok := j.samples[name]
+	if !ok {
+		return 0, fmt.Errorf("sample %q not in vcf", name)
+	}
+	return i, nil
+}
+
+// Format returns a FORMAT value for a sample, numbers and lists parsed
+func (j *jsVariant) Format(name, key string) (interface{}, error) {
+	i, err := j.sample(name)
+	if err != nil || i >= len(j.v.Samples) || j.v.Samples[i] == nil {
+		return nil, err
+	}
+	raw, ok := sampleField(j.v.Samples[i], key)
+	if !ok {
+		return nil, nil
+	}
+	if key == "GT" {
+		return raw, nil
+	}
+	return rawValue(raw), nil
+}
+
+// Gt returns hom_ref, het, hom_alt or missing for a sample
+func (j *jsVariant) Gt(name string) (interface{}, error) {
+	i, err := j.sample(name)
+	if err != nil {
+		return nil, err
+	}
+	return sampleValue(j.v, i, "GT"), nil
+}
+
+func (j *jsVariant) Csq() []map[string]string {
+	return getCSQ(j.v, j.csqKeys)
+}
+
+func (j *jsVariant) CsqSevere() map[string]string {
+	if acsq := j.Csq(); len(acsq) > 0 {
+		return rankSevere(acsq)
+	}
+	return nil
+}
+
+func (j *jsVariant) CsqCanonical() map[string]string {
+	if acsq := j.Csq(); len(acsq) > 0 {
+		return rankCanon(acsq)
+	}
+	return nil
+}
+
+type script struct {
+	file  string
+	infos infoFields
+}
+
+func (*script) Name() string { return "script" }
+func (*script) Synopsis() string {
+	return "annotate or drop variants with a javascript annotate(v) function"
+}
+func (*script) Usage() string {
+	return `script -file rules.js [-overwrite | -suffix _2] < in.vcf > out.vcf
+
+The script runs once to declare header lines, then annotate(v) is called per
+variant. Returning false drops the variant.
+
+  addInfo("my_tier", "1", "Float", "prototype tier")
+  function annotate(v) {
+    if (isLGD(v) && isRare(v) && v.gt("proband") == "het") {
+      v.set("my_tier", 1.5)
+    }
+  }
+
+v has chrom, pos, id, ref, alt, qual and filter, and info(key), set(key, value),
+format(sample, key), gt(sample), csq(), csqSevere(), csqCanonical() and
+setFilter(name). Helpers: isDmis, isLGD, isRare, isConstrained, isSpliceDamage
+and getGnomAD take v. addFilter(id, description) declares a FILTER. An
+addInfo ID the input already has is refused unless -overwrite or -suffix is
+given, set(key, value) writes to the ID the field was added under.
+`
+}
+
+func (s *script) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&s.file, "file", "", "javascript file defining annotate(v)")
+	s.infos.setFlags(f)
+}
+
+func (s *script) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := statsFrom(args)
+	ep := errPolicyFrom(args)
+	src, err := os.ReadFile(s.file)
+	if err != nil {
+		return ep.fatal(err)
+	}
+
+	rdr, err := vcfgo.NewReader(os.Stdin, false)
+	if err != nil {
+		return ep.fatal(err)
+	}
+
+	// CSQ is optional, csq() returns nothing without it
+	csqKeys, _ := getCSQKeys(rdr.Header)
+	samples := map[string]int{}
+	for i, name := range rdr.Header.SampleNames {
+		samples[name] = i
+	}
+
+	rk := &ranker{}
+	vm := goja.New()
+	vm.SetFieldNameMapper(goja.TagFieldNameMapper("json", true))
+
+	var headerDone bool
+	globals := map[string]interface{}{
+		"addInfo": func(id, number, typ, desc string) error {
+			if headerDone {
+				return fmt.Errorf("addInfo must be called at the top level of the script")
+			}
+			return s.infos.add(rdr, id, number, typ, desc)
+		},
+		"addFilter": func(id, desc string) error {
+			if headerDone {
+				return fmt.Errorf("addFilter must be called at the top level of the script")
+			}
+			rdr.Header.Filters[id] = desc
+			return nil
+		},
+		"isDmis":         func(j *jsVariant) bool { return rk.isDmis(j.v) },
+		"isLGD":          func(j *jsVariant) bool { return rk.isLGD(j.v) },
+		"isRare":         func(j *jsVariant) bool { return rk.isRare(j.v) },
+		"isConstrained":  func(j *jsVariant) bool { return rk.isConstrained(j.v) },
+		"isSpliceDamage": func(j *jsVariant) bool { return rk.isSpliceDamage(j.v) },
+		"getGnomAD":      func(j *jsVariant) float64 { return getGnomAD(j.v) },
+	}
+	for name, fn := range globals {
+		if err := vm.Set(name, fn); err != nil {
+			return ep.fatal(err)
+		}
+	}
+
+	if _, err := vm.RunScript(s.file, string(src)); err != nil {
+		return ep.fatal(err)
+	}
+	annotate, ok := goja.AssertFunction(vm.Get("annotate"))
+	if !ok {
+		return ep.fatal(fmt.Errorf("%s does not define annotate(v)", s.file))
+	}
+	headerDone = true
+
+	addProvenance(rdr.Header, ep.cmd)
+	wrt, err := vcfgo.NewWriter(os.Stdout, rdr.Header)
+	if err != nil {
+		return ep.fatal(err)
+	}
+
+	for {
+		variant := rdr.Read()
+		if variant == nil {
+			break
+		}
+		st.RecordsIn++
+		if err := readErr(rdr); err != nil {
+			if !ep.record(variantWhere(variant), err) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+
+		s.infos.clear(variant)
+		jv := &jsVariant{
+			v:       variant,
+			csqKeys: csqKeys,
+			samples: samples,
+			infos:   &s.infos,
+			Chrom:   variant.Chromosome,
+			Pos:     int(variant.Pos),
+			ID:      variant.Id_,
+			Ref:     variant.Reference,
+			Alt:     variant.Alternate,
+			Qual:    float64(variant.Quality),
+			Filter:  variant.Filter,
+		}
+		ret, err := annotate(goja.Undefined(), vm.ToValue(jv))
+		if err != nil {
+			if !ep.record(variantWhere(variant), err) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+		if ret.StrictEquals(vm.ToValue(false)) {
+			st.count("dropped")
+			continue
+		}
+		st.RecordsOut++
+		wrt.WriteVariant(variant)
+	}
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brentp/vcfgo"
+	"github.com/google/subcommands"
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS families (
+	name   TEXT PRIMARY KEY,
+	loaded TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS samples (
+	id     INTEGER PRIMARY KEY,
+	family TEXT NOT NULL REFERENCES families(name),
+	name   TEXT NOT NULL,
+	UNIQUE (family, name)
+);
+CREATE TABLE IF NOT EXISTS variants (
+	id           INTEGER PRIMARY KEY,
+	family       TEXT NOT NULL REFERENCES families(name),
+	chrom        TEXT NOT NULL,
+	pos          INTEGER NOT NULL,
+	ref          TEXT NOT NULL,
+	alt          TEXT NOT NULL,
+	qual         REAL,
+	filter       TEXT,
+	gene         TEXT,
+	rank         REAL,
+	comphet_rank REAL,
+	info         TEXT
+);
+CREATE TABLE IF NOT EXISTS genotypes (
+	variant_id INTEGER NOT NULL REFERENCES variants(id),
+	sample_id  INTEGER NOT NULL REFERENCES samples(id),
+	gt         TEXT,
+	alt_count  INTEGER,
+	dp         INTEGER,
+	gq         INTEGER,
+	PRIMARY KEY (variant_id, sample_id)
+);
+CREATE TABLE IF NOT EXISTS transcripts (
+	variant_id     INTEGER NOT NULL REFERENCES variants(id),
+	idx            INTEGER NOT NULL,
+	gene           TEXT,
+	feature        TEXT,
+	consequence    TEXT,
+	impact         TEXT,
+	severe_pick    INTEGER NOT NULL,
+	canonical_pick INTEGER NOT NULL,
+	csq            TEXT,
+	PRIMARY KEY (variant_id, idx)
+);
+CREATE TABLE IF NOT EXISTS comphet (
+	family     TEXT NOT NULL REFERENCES families(name),
+	sample_id  INTEGER NOT NULL REFERENCES samples(id),
+	gene       TEXT,
+	pair_id    TEXT NOT NULL,
+	variant_id INTEGER NOT NULL REFERENCES variants(id)
+);
+CREATE INDEX IF NOT EXISTS variants_gene ON variants(gene);
+CREATE INDEX IF NOT EXISTS variants_rank ON variants(rank);
+CREATE INDEX IF NOT EXISTS variants_family ON variants(family);
+CREATE INDEX IF NOT EXISTS genotypes_sample ON genotypes(sample_id);
+CREATE INDEX IF NOT EXISTS transcripts_gene ON transcripts(gene);
+CREATE INDEX IF NOT EXISTS comphet_pair ON comphet(family, pair_id);
+CREATE VIEW IF NOT EXISTS comphet_pairs AS
+	SELECT a.family, s.name AS sample, a.sample_id, a.gene, a.pair_id, a.variant_id AS variant1, b.variant_id AS variant2
+	FROM comphet a JOIN comphet b
+	ON a.family = b.family AND a.sample_id = b.sample_id AND a.pair_id = b.pair_id AND a.variant_id < b.variant_id
+	JOIN samples s ON s.id = a.sample_id;
+`
+
+type toSqlite struct {
+	db      string
+	family  string
+	replace bool
+}
+
+func (*toSqlite) Name() string { return "toSqlite" }
+func (*toSqlite) Synopsis() string {
+	return "load a ranked vcf into a sqlite database of variants, samples, genotypes, transcripts and comp het pairs"
+}
+func (*toSqlite) Usage() string {
+	return `toSqlite -db variants.db [-family name] [-replace] < ranked.vcf
+
+Each run adds one family to the database, creating it if needed. Tables:
+  families     name, load time
+  samples      id, family, name
+  variants     id, family, chrom, pos, ref, alt, qual, filter, gene, rank,
+               comphet_rank, info (every INFO field as json)
+  genotypes    variant_id, sample_id, gt, alt_count, dp, gq
+  transcripts  variant_id, idx, gene, feature, consequence, impact,
+               severe_pick and canonical_pick (1 on the entries rankSevere
+               and rankCanon choose), csq (the entry as json)
+  comphet      family, sample_id, gene, pair_id, variant_id from slivar_comphet
+  comphet_pairs  view of comphet joined into variant1, variant2 pairs, with
+               the sample name
+gene is vep_SYMBOL, or SYMBOL of the most severe CSQ entry.
+`
+}
+
+func (t *toSqlite) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&t.db, "db", "", "sqlite database file, created if it does not exist")
+	f.StringVar(&t.family, "family", "", "family the vcf is loaded as (default the first sample name)")
+	f.BoolVar(&t.replace, "replace", false, "replace the family if the database has it already")
+}
+
+// sqlNull is nil for a missing value so it is stored as NULL
+func sqlNull(s string) interface{} {
+	if s == "" || s == "." {
+		return nil
+	}
+	return s
+}
+
+func sqlNullNumber(s string) interface{} {
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return n
+	}
+	return nil
+}
+
+// deleteFamily removes a family's rows from every table
+func deleteFamily(tx *sql.Tx, family string) error {
+	for _, q := range []string{
+		`DELETE FROM genotypes WHERE variant_id IN (SELECT id FROM variants WHERE family = ?)`,
+		`DELETE FROM transcripts WHERE variant_id IN (SELECT id FROM variants WHERE family = ?)`,
+		`DELETE FROM comphet WHERE family = ?`,
+		`DELETE FROM variants WHERE family = ?`,
+		`DELETE FROM samples WHERE family = ?`,
+		`DELETE FROM families WHERE name = ?`,
+	} {
+		if _, err := tx.Exec(q, family); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *toSqlite) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := statsFrom(args)
+	ep := errPolicyFrom(args)
+	if t.db == "" {
+		return ep.usage("-db is required")
+	}
+
+	rdr, err := vcfgo.NewReader(os.Stdin, false)
+	if err != nil {
+		return ep.fatal(err)
+	}
+	family := t.family
+	if family == "" {
+		if len(rdr.Header.SampleNames) == 0 {
+			return ep.usage("the vcf has no samples, give -family")
+		}
+		family = rdr.Header.SampleNames[0]
+	}
+	var csqKeys []string
+	if _, ok := rdr.Header.Infos["CSQ"]; ok {
+		if csqKeys, err = getCSQKeys(rdr.Header); err != nil {
+			return ep.fatal(err)
+		}
+	}
+
+	db, err := sql.Open("sqlite", t.db)
+	if err != nil {
+		return ep.fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return ep.fatal(err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return ep.fatal(err)
+	}
+	defer tx.Rollback()
+
+	var n int
+	if err := tx.QueryRow(`SELECT count(*) FROM families WHERE name = ?`, family).Scan(&n); err != nil {
+		return ep.fatal(err)
+	}
+	if n > 0 {
+		if !t.replace {
+			return ep.fatal(fmt.Errorf("%s already has family %s, use -replace to load it again", t.db, family))
+		}
+		if err := deleteFamily(tx, family); err != nil {
+			return ep.fatal(err)
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO families (name, loaded) VALUES (?, ?)`, family, time.Now().Format(time.RFC3339)); err != nil {
+		return ep.fatal(err)
+	}
+
+	sampleIDs := make([]int64, len(rdr.Header.SampleNames))
+	sampleByName := map[string]int64{}
+	for i, s := range rdr.Header.SampleNames {
+		res, err := tx.Exec(`INSERT INTO samples (family, name) VALUES (?, ?)`, family, s)
+		if err != nil {
+			return ep.fatal(err)
+		}
+		if sampleIDs[i], err = res.LastInsertId(); err != nil {
+			return ep.fatal(err)
+		}
+		sampleByName[s] = sampleIDs[i]
+	}
+
+	insVariant, err := tx.Prepare(`INSERT INTO variants (family, chrom, pos, ref, alt, qual, filter, gene, rank, comphet_rank, info) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return ep.fatal(err)
+	}
+	insGenotype, err := tx.Prepare(`INSERT INTO genotypes (variant_id, sample_id, gt, alt_count, dp, gq) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return ep.fatal(err)
+	}
+	insTranscript, err := tx.Prepare(`INSERT INTO transcripts (variant_id, idx, gene, feature, consequence, impact, severe_pick, canonical_pick, csq) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return ep.fatal(err)
+	}
+	insCompHet, err := tx.Prepare(`INSERT INTO comphet (family, sample_id, gene, pair_id, variant_id) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return ep.fatal(err)
+	}
+
+	for {
+		variant := rdr.Read()
+		if variant == nil {
+			break
+		}
+		st.RecordsIn++
+		if err := readErr(rdr); err != nil {
+			if !ep.record(variantWhere(variant), err) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+
+		var acsq []map[string]string
+		if csqKeys != nil {
+			acsq = getCSQ(variant, csqKeys)
+		}
+		severe, canon := csqChoice(acsq)
+		gene := infoFirst(variant, "vep_SYMBOL")
+		if gene == "" && severe >= 0 {
+			gene = acsq[severe]["SYMBOL"]
+		}
+
+		info := map[string]string{}
+		for _, k := range variant.Info().Keys() {
+			if val, err := variant.Info().Get(k); err == nil {
+				info[k] = strings.Join(infoStrings(val), ",")
+			}
+		}
+		infoJSON, err := json.Marshal(info)
+		if err != nil {
+			return ep.fatal(err)
+		}
+
+		res, err := insVariant.Exec(family, variant.Chromosome, variant.Pos, variant.Reference, strings.Join(variant.Alternate, ","),
+			float64(variant.Quality), sqlNull(variant.Filter), sqlNull(gene),
+			sqlNullNumber(infoFirst(variant, "rank")), sqlNullNumber(infoFirst(variant, "comphet_rank")), string(infoJSON))
+		if err != nil {
+			return ep.fatal(err)
+		}
+		vid, err := res.LastInsertId()
+		if err != nil {
+			return ep.fatal(err)
+		}
+
+		for i, s := range variant.Samples {
+			if i >= len(sampleIDs) || s == nil {
+				continue
+			}
+			gt, _ := sampleField(s, "GT")
+			altCount := 0
+			for _, a := range s.GT {
+				if a > 0 {
+					altCount++
+				}
+			}
+			dp, _ := sampleField(s, "DP")
+			gq, _ := sampleField(s, "GQ")
+			if _, err := insGenotype.Exec(vid, sampleIDs[i], sqlNull(gt), altCount, sqlNullNumber(dp), sqlNullNumber(gq)); err != nil {
+				return ep.fatal(err)
+			}
+		}
+
+		for i, c := range acsq {
+			csqJSON, err := json.Marshal(c)
+			if err != nil {
+				return ep.fatal(err)
+			}
+			if _, err := insTranscript.Exec(vid, i, sqlNull(c["SYMBOL"]), sqlNull(c["Feature"]), sqlNull(c["Consequence"]), sqlNull(c["IMPACT"]),
+				i == severe, i == canon, string(csqJSON)); err != nil {
+				return ep.fatal(err)
+			}
+		}
+
+		if chI, err := variant.Info().Get("slivar_comphet"); err == nil {
+			for _, ch := range infoStrings(chI) {
+				ls := strings.Split(ch, "/")
+				if len(ls) < 3 {
+					if !ep.record(variantWhere(variant), fmt.Errorf("malformed slivar_comphet %q", ch)) {
+						return subcommands.ExitFailure
+					}
+					continue
+				}
+				sid, ok := sampleByName[ls[0]]
+				if !ok {
+					if !ep.record(variantWhere(variant), fmt.Errorf("slivar_comphet sample %s is not in the vcf", ls[0])) {
+						return subcommands.ExitFailure
+					}
+					continue
+				}
+				if _, err := insCompHet.Exec(family, sid, sqlNull(ls[1]), ls[2], vid); err != nil {
+					return ep.fatal(err)
+				}
+				st.count("comphet")
+			}
+		}
+		st.RecordsOut++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ep.fatal(err)
+	}
+	return subcommands.ExitSuccess
+}
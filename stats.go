@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// runStats is the -stats summary of one run. Commands find it with
+// statsFrom and fill in the counts that matter to them.
+type runStats struct {
+	Command    string         `json:"command"`
+	Status     string         `json:"status"`
+	Seconds    float64        `json:"seconds"`
+	RecordsIn  int            `json:"records_in"`
+	RecordsOut int            `json:"records_out"`
+	Counts     map[string]int `json:"counts,omitempty"`
+}
+
+func (s *runStats) count(key string) {
+	s.Counts[key]++
+}
+
+// statsFrom returns the runStats wrap passes to Execute, or a throwaway
+// one when the command is run some other way
+func statsFrom(args []interface{}) *runStats {
+	for _, a := range args {
+		if st, ok := a.(*runStats); ok {
+			return st
+		}
+	}
+	return &runStats{Counts: map[string]int{}}
+}
+
+func (s *runStats) write(path string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
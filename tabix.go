@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/biogo/hts/bgzf"
+	"github.com/biogo/hts/bgzf/cache"
+	"github.com/biogo/hts/bgzf/index"
+	"github.com/biogo/hts/tabix"
+)
+
+// annRecord is one line of an annotation source, start and end are 0 based
+// half open
+type annRecord struct {
+	chrom      string
+	start, end int
+	ref        string
+	alts       []string
+	vals       map[string]string
+}
+
+type annSource interface {
+	query(chrom string, start, end int) ([]*annRecord, error)
+}
+
+// openAnnSource uses the tabix index next to path when there is one and
+// otherwise loads the file into memory. It also returns the # header lines.
+func openAnnSource(path string, parse func(string) (*annRecord, error)) (annSource, []string, error) {
+	if _, err := os.Stat(path + ".tbi"); err == nil {
+		return openTabixSource(path, parse)
+	}
+	return loadMemSource(path, parse)
+}
+
+// tabixSource queries a bgzipped file through its tabix index
+type tabixSource struct {
+	bgzf  *bgzf.Reader
+	idx   *tabix.Index
+	names map[string]string // bare contig name to the name in the index
+	parse func(string) (*annRecord, error)
+}
+
+func openTabixSource(path string, parse func(string) (*annRecord, error)) (annSource, []string, error) {
+	ifile, err := os.Open(path + ".tbi")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ifile.Close()
+	gz, err := gzip.NewReader(ifile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s.tbi: %s", path, err)
+	}
+	idx, err := tabix.ReadFrom(bufio.NewReader(gz))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s.tbi: %s", path, err)
+	}
+	if idx == nil {
+		idx = tabix.New()
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	bg, err := bgzf.NewReader(file, 1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", path, err)
+	}
+	bg.SetCache(cache.NewLRU(64))
+	t := &tabixSource{bgzf: bg, idx: idx, names: map[string]string{}, parse: parse}
+	for _, name := range idx.Names() {
+		t.names[bareChrom(name)] = name
+	}
+
+	var header []string
+	r := bufio.NewReader(bg)
+	for {
+		line, err := r.ReadString('\n')
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		header = append(header, strings.TrimRight(line, "\r\n"))
+		if err != nil {
+			break
+		}
+	}
+	return t, header, nil
+}
+
+func (t *tabixSource) query(chrom string, start, end int) ([]*annRecord, error) {
+	name, ok := t.names[bareChrom(chrom)]
+	if !ok {
+		return nil, nil
+	}
+	chunks, err := t.idx.Chunks(name, start, end)
+	switch {
+	case err == index.ErrInvalid || err == nil && len(chunks) == 0:
+		// past the last record of the contig
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	cr, err := index.NewChunkReader(t.bgzf, chunks)
+	if err != nil {
+		return nil, err
+	}
+	defer cr.Close()
+
+	var out []*annRecord
+	scanner := bufio.NewScanner(cr)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		r, err := t.parse(line)
+		if err != nil {
+			return nil, err
+		}
+		if r.chrom == name && r.start < end && r.end > start {
+			out = append(out, r)
+		}
+	}
+	return out, scanner.Err()
+}
+
+// memSource holds a whole unindexed file, records sorted by start per contig
+type memSource struct {
+	recs   map[string][]*annRecord
+	maxLen map[string]int
+}
+
+func loadMemSource(path string, parse func(string) (*annRecord, error)) (annSource, []string, error) {
+	rc, err := openMaybeGzip(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rc.Close()
+
+	m := &memSource{recs: map[string][]*annRecord{}, maxLen: map[string]int{}}
+	var header []string
+	scanner := bufio.NewScanner(rc)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		if line[0] == '#' {
+			header = append(header, line)
+			continue
+		}
+		rec, err := parse(line)
+		if err != nil {
+			return nil, nil, err
+		}
+		c := bareChrom(rec.chrom)
+		m.recs[c] = append(m.recs[c], rec)
+		if l := rec.end - rec.start; l > m.maxLen[c] {
+			m.maxLen[c] = l
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	for _, recs := range m.recs {
+		sort.SliceStable(recs, func(i, j int) bool { return recs[i].start < recs[j].start })
+	}
+	return m, header, nil
+}
+
+func (m *memSource) query(chrom string, start, end int) ([]*annRecord, error) {
+	chrom = bareChrom(chrom)
+	recs := m.recs[chrom]
+	lo := sort.Search(len(recs), func(i int) bool { return recs[i].start >= start-m.maxLen[chrom] })
+	var out []*annRecord
+	for i := lo; i < len(recs) && recs[i].start < end; i++ {
+		if recs[i].end > start {
+			out = append(out, recs[i])
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/bgzf"
+)
+
+// countWriter tracks the compressed offset bgzf blocks are written at
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// reg2bin is the tabix bin of a 0 based half open interval, from the spec
+func reg2bin(beg, end int) uint32 {
+	end--
+	for _, l := range []struct{ shift, offset int }{{14, 4681}, {17, 585}, {20, 73}, {23, 9}, {26, 1}} {
+		if beg>>l.shift == end>>l.shift {
+			return uint32(l.offset + beg>>l.shift)
+		}
+	}
+	return 0
+}
+
+type tbiRef struct {
+	name   string
+	bins   map[uint32][][2]uint64
+	linear []uint64
+}
+
+// writeTBI writes a tabix index for a vcf laid out as the spec describes,
+// independently of the reader, one chunk per record
+func writeTBI(t *testing.T, path string, refs []*tbiRef) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bg := bgzf.NewWriter(file, 1)
+	var names []byte
+	for _, r := range refs {
+		names = append(append(names, r.name...), 0)
+	}
+	le := binary.LittleEndian
+	put := func(v interface{}) {
+		if err := binary.Write(bg, le, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put([]byte("TBI\x01"))
+	// n_ref, then format vcf, seq, beg and end columns, meta char and skip
+	put([]int32{int32(len(refs)), 2, 1, 2, 0, '#', 0, int32(len(names))})
+	put(names)
+	for _, r := range refs {
+		put(int32(len(r.bins)))
+		for bin, chunks := range r.bins {
+			put(bin)
+			put(int32(len(chunks)))
+			put(chunks)
+		}
+		put(int32(len(r.linear)))
+		put(r.linear)
+	}
+	if err := bg.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+}
+
+// writeTabixFixture bgzips a vcf with every record in its own block, those
+// in split spread over two blocks, and indexes it with tabix
+func writeTabixFixture(t *testing.T, header, lines []string, split map[int]bool) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ann.vcf.gz")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cw := &countWriter{w: file}
+	bg := bgzf.NewWriter(cw, 1)
+	write := func(s string) {
+		if _, err := bg.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flush := func() int64 {
+		if err := bg.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if err := bg.Wait(); err != nil {
+			t.Fatal(err)
+		}
+		return cw.n
+	}
+
+	write(strings.Join(header, "\n") + "\n")
+	flush()
+	var refs []*tbiRef
+	for i, line := range lines {
+		begin := cw.n
+		if split[i] {
+			write(line[:len(line)/2])
+			flush()
+			write(line[len(line)/2:] + "\n")
+		} else {
+			write(line + "\n")
+		}
+		end := flush()
+		ls := strings.Split(line, "\t")
+		pos, _ := strconv.Atoi(ls[1])
+		start, stop := pos-1, pos-1+len(ls[3])
+		if len(refs) == 0 || refs[len(refs)-1].name != ls[0] {
+			refs = append(refs, &tbiRef{name: ls[0], bins: map[uint32][][2]uint64{}})
+		}
+		ref := refs[len(refs)-1]
+		voff := uint64(begin) << 16
+		bin := reg2bin(start, stop)
+		ref.bins[bin] = append(ref.bins[bin], [2]uint64{voff, uint64(end) << 16})
+		for w := start >> 14; w <= (stop-1)>>14; w++ {
+			for len(ref.linear) <= w {
+				ref.linear = append(ref.linear, 0)
+			}
+			if ref.linear[w] == 0 {
+				ref.linear[w] = voff
+			}
+		}
+	}
+	if err := bg.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	writeTBI(t, path+".tbi", refs)
+	return path
+}
+
+func TestTabixSource(t *testing.T) {
+	header := []string{"##fileformat=VCFv4.2", "#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO"}
+	lines := []string{
+		"chr1\t100\t.\tA\tG\t.\t.\tAF=0.1",
+		"chr1\t200\t.\tACGT\tA\t.\t.\tAF=0.2;NOTE=" + strings.Repeat("x", 200),
+		"chr1\t70000\t.\tC\tT\t.\t.\tAF=0.3",
+		"chr2\t50\t.\tG\tC\t.\t.\tAF=0.4",
+	}
+	path := writeTabixFixture(t, header, lines, map[int]bool{1: true})
+	parse := vcfRecordParser([]string{"AF"})
+
+	src, hdr, err := openAnnSource(path, parse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := src.(*tabixSource); !ok {
+		t.Fatalf("got %T, want the tabix index used", src)
+	}
+	if len(hdr) != 2 || hdr[1] != header[1] {
+		t.Errorf("header %q", hdr)
+	}
+
+	mem, _, err := loadMemSource(path, parse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		chrom      string
+		start, end int
+		want       []string
+	}{
+		{"record over two blocks", "chr1", 199, 200, []string{"0.2"}},
+		{"inside a deletion", "chr1", 201, 202, []string{"0.2"}},
+		{"chunks across blocks", "chr1", 0, 100000, []string{"0.1", "0.2", "0.3"}},
+		{"bare contig name", "1", 99, 100, []string{"0.1"}},
+		{"back to an earlier position", "chr1", 99, 100, []string{"0.1"}},
+		{"far linear index bin", "1", 69999, 70000, []string{"0.3"}},
+		{"other contig", "2", 49, 50, []string{"0.4"}},
+		{"between records", "chr1", 1000, 1001, nil},
+		{"past the last record", "chr1", 900000, 900001, nil},
+		{"unknown contig", "chrX", 0, 100, nil},
+	} {
+		for _, s := range []annSource{src, mem} {
+			recs, err := s.query(tc.chrom, tc.start, tc.end)
+			if err != nil {
+				t.Fatalf("%s: %T: %s", tc.name, s, err)
+			}
+			var got []string
+			for _, r := range recs {
+				got = append(got, r.vals["AF"])
+			}
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("%s: %T: got %v, want %v", tc.name, s, got, tc.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/vcfgo"
+	"github.com/google/subcommands"
+)
+
+type tableColumn struct {
+	kind   string // CHROM, POS, ID, REF, ALT, QUAL, FILTER, INFO, FORMAT or CSQ
+	key    string
+	number string
+}
+
+// parseColumns resolves a -columns spec such as CHROM,POS,INFO/rank,FORMAT/GT
+// against the header. Bare names are taken as INFO fields.
+func parseColumns(spec string, h *vcfgo.Header) ([]tableColumn, error) {
+	var cols []tableColumn
+	for _, c := range strings.Split(spec, ",") {
+		c = strings.TrimSpace(c)
+		kind, key, ok := strings.Cut(c, "/")
+		if !ok {
+			switch c {
+			case "CHROM", "POS", "ID", "REF", "ALT", "QUAL", "FILTER":
+				cols = append(cols, tableColumn{kind: c})
+				continue
+			}
+			kind, key = "INFO", c
+		}
+
+		col := tableColumn{kind: kind, key: key, number: "1"}
+		switch kind {
+		case "INFO":
+			if info, ok := h.Infos[key]; ok {
+				col.number = info.Number
+			}
+		case "FORMAT":
+			if sf, ok := h.SampleFormats[key]; ok {
+				col.number = sf.Number
+			}
+		case "CSQ":
+		default:
+			return nil, fmt.Errorf("unknown column %q, expected CHROM, POS, ID, REF, ALT, QUAL, FILTER, INFO/key, FORMAT/key or CSQ/key", c)
+		}
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
+
+// infoStrings flattens a typed INFO value into its comma separated parts
+func infoStrings(val interface{}) []string {
+	switch val := val.(type) {
+	case nil:
+		return nil
+	case string:
+		return strings.Split(val, ",")
+	case bool:
+		if val {
+			return []string{"1"}
+		}
+		return nil
+	case int:
+		return []string{strconv.Itoa(val)}
+	case float32:
+		return []string{strconv.FormatFloat(float64(val), 'g', -1, 32)}
+	case float64:
+		return []string{strconv.FormatFloat(val, 'g', -1, 64)}
+	case []string:
+		return val
+	case []int:
+		s := make([]string, len(val))
+		for i, v := range val {
+			s[i] = strconv.Itoa(v)
+		}
+		return s
+	case []float32:
+		s := make([]string, len(val))
+		for i, v := range val {
+			s[i] = strconv.FormatFloat(float64(v), 'g', -1, 32)
+		}
+		return s
+	case []float64:
+		s := make([]string, len(val))
+		for i, v := range val {
+			s[i] = strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		return s
+	case []interface{}:
+		var s []string
+		for _, v := range val {
+			s = append(s, infoStrings(v)...)
+		}
+		return s
+	}
+	return []string{fmt.Sprint(val)}
+}
+
+// infoFirst returns the first of the given INFO fields that is set
+func infoFirst(v *vcfgo.Variant, keys ...string) string {
+	for _, k := range keys {
+		val, err := v.Info().Get(k)
+		if err != nil || val == nil {
+			continue
+		}
+		if s := strings.Join(infoStrings(val), ","); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+func infoFloat(v *vcfgo.Variant, key string) float64 {
+	f, err := strconv.ParseFloat(infoFirst(v, key), 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
+// sampleField returns the raw FORMAT value for a sample, building GT from the
+// parsed genotype if the reader did not keep it in Fields.
+func sampleField(s *vcfgo.SampleGenotype, key string) (string, bool) {
+	if v, ok := s.Fields[key]; ok {
+		return v, true
+	}
+	if key != "GT" || len(s.GT) == 0 {
+		return "", false
+	}
+	sep := "/"
+	if s.Phased {
+		sep = "|"
+	}
+	alleles := make([]string, len(s.GT))
+	for i, a := range s.GT {
+		if a < 0 {
+			alleles[i] = "."
+		} else {
+			alleles[i] = strconv.Itoa(a)
+		}
+	}
+	return strings.Join(alleles, sep), true
+}
+
+// selectAllele picks the values belonging to alt allele ai from a Number=A or
+// Number=R list. ai < 0 keeps every value.
+func selectAllele(vals []string, number string, ai int) []string {
+	if ai < 0 {
+		return vals
+	}
+	switch number {
+	case "A":
+		if ai < len(vals) {
+			return vals[ai : ai+1]
+		}
+		return nil
+	case "R":
+		if ai+1 < len(vals) {
+			return []string{vals[0], vals[ai+1]}
+		}
+		return nil
+	}
+	return vals
+}
+
+type toTable struct {
+	columns   string
+	format    string
+	missing   string
+	splitAlts bool
+	csq       string
+}
+
+func (*toTable) Name() string { return "toTable" }
+func (*toTable) Synopsis() string {
+	return "write selected vcf columns, INFO, FORMAT and CSQ fields as a tsv/csv table"
+}
+func (*toTable) Usage() string {
+	return `toTable -columns CHROM,POS,REF,ALT,INFO/rank,FORMAT/GT,CSQ/SYMBOL [-format tsv|csv] [-split-alts] [-csq severe|canonical|all]
+
+-csq all writes a row per CSQ entry, followed by CSQ_index (the entry's
+position in CSQ) and CSQ_severe and CSQ_canonical, 1 on the rows rankSevere
+and rankCanon pick. With -split-alts each alt only gets the CSQ entries
+whose Allele is that alt, trimmed as VEP trims it.
+`
+}
+
+func (t *toTable) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&t.columns, "columns", "CHROM,POS,REF,ALT", "comma sep columns: CHROM, POS, ID, REF, ALT, QUAL, FILTER, INFO/key, FORMAT/key (one column per sample) or CSQ/key")
+	f.StringVar(&t.format, "format", "tsv", "output format (tsv, csv)")
+	f.StringVar(&t.missing, "missing", ".", "value written for missing fields")
+	f.BoolVar(&t.splitAlts, "split-alts", false, "write one row per alt allele, splitting Number=A and Number=R fields")
+	f.StringVar(&t.csq, "csq", "severe", "which CSQ transcript CSQ/ columns come from (severe, canonical, or all for a row per transcript)")
+}
+
+// vepAllele is alt i as VEP writes it in the CSQ Allele field. When every
+// allele starts with the same base and they are not all single bases, VEP
+// drops that base, writing - for an allele left empty.
+func vepAllele(ref string, alts []string, i int) string {
+	alt := alts[i]
+	if ref == "" {
+		return alt
+	}
+	single := len(ref) == 1
+	for _, a := range alts {
+		if a == "" || a[0] != ref[0] {
+			return alt
+		}
+		single = single && len(a) == 1
+	}
+	if single {
+		return alt
+	}
+	if alt = alt[1:]; alt == "" {
+		return "-"
+	}
+	return alt
+}
+
+func (t *toTable) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := statsFrom(args)
+	ep := errPolicyFrom(args)
+	rdr, err := vcfgo.NewReader(os.Stdin, false)
+	if err != nil {
+		return ep.fatal(err)
+	}
+
+	cols, err := parseColumns(t.columns, rdr.Header)
+	if err != nil {
+		return ep.fatal(err)
+	}
+
+	switch t.csq {
+	case "severe", "canonical", "all":
+	default:
+		return ep.usage(fmt.Sprintf("unknown -csq %q, expected severe, canonical or all", t.csq))
+	}
+
+	var csqKeys []string
+	for _, c := range cols {
+		if c.kind == "CSQ" || t.csq == "all" {
+			csqKeys, err = getCSQKeys(rdr.Header)
+			if err != nil {
+				return ep.fatal(err)
+			}
+			break
+		}
+	}
+	if t.splitAlts && csqKeys != nil {
+		hasAllele := false
+		for _, k := range csqKeys {
+			hasAllele = hasAllele || k == "Allele"
+		}
+		if !hasAllele {
+			return ep.usage("-split-alts with CSQ columns needs the CSQ Allele field to match entries to alts")
+		}
+	}
+
+	wrt, err := tableWriter(os.Stdout, t.format)
+	if err != nil {
+		return ep.usage(err.Error())
+	}
+	defer wrt.Flush()
+
+	if err := wrt.Write(t.header(cols, rdr.Header.SampleNames)); err != nil {
+		return ep.fatal(err)
+	}
+
+	for {
+		variant := rdr.Read()
+		if variant == nil {
+			break
+		}
+		st.RecordsIn++
+		if err := readErr(rdr); err != nil {
+			if !ep.record(variantWhere(variant), err) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+
+		if err := t.writeRows(wrt, st, variant, cols, csqKeys, len(rdr.Header.SampleNames)); err != nil {
+			return ep.fatal(err)
+		}
+	}
+	return subcommands.ExitSuccess
+}
+
+// tableWriter writes tsv or csv, quoting fields that hold the separator
+func tableWriter(w io.Writer, format string) (*csv.Writer, error) {
+	wrt := csv.NewWriter(w)
+	switch format {
+	case "tsv":
+		wrt.Comma = '\t'
+	case "csv":
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected tsv or csv", format)
+	}
+	return wrt, nil
+}
+
+// header names the table columns, FORMAT columns once per sample
+func (t *toTable) header(cols []tableColumn, samples []string) []string {
+	var header []string
+	for _, c := range cols {
+		switch c.kind {
+		case "INFO", "CSQ":
+			header = append(header, c.key)
+		case "FORMAT":
+			for _, s := range samples {
+				header = append(header, s+":"+c.key)
+			}
+		default:
+			header = append(header, c.kind)
+		}
+	}
+	if t.csq == "all" {
+		header = append(header, "CSQ_index", "CSQ_severe", "CSQ_canonical")
+	}
+	return header
+}
+
+// writeRows writes the rows of one variant, one per alt with -split-alts
+// and one per CSQ entry with -csq all
+func (t *toTable) writeRows(wrt *csv.Writer, st *runStats, variant *vcfgo.Variant, cols []tableColumn, csqKeys []string, nsamples int) error {
+	join := func(vals []string) string {
+		if len(vals) == 0 {
+			return t.missing
+		}
+		out := make([]string, len(vals))
+		for i, v := range vals {
+			if v == "" || v == "." {
+				v = t.missing
+			}
+			out[i] = v
+		}
+		return strings.Join(out, ",")
+	}
+
+	var acsq []map[string]string
+	if csqKeys != nil {
+		if acsq = getCSQ(variant, csqKeys); len(acsq) == 0 {
+			st.count("no_csq")
+		}
+	}
+
+	alleles := []int{-1}
+	if t.splitAlts {
+		alleles = alleles[:0]
+		for i := range variant.Alternate {
+			alleles = append(alleles, i)
+		}
+	}
+
+	yesNo := func(b bool) string {
+		if b {
+			return "1"
+		}
+		return "0"
+	}
+
+	for _, ai := range alleles {
+		// the CSQ entries to write a row for, with their index in CSQ,
+		// one nil entry when there are none. Split alts only get the
+		// entries whose Allele is theirs.
+		var idx []int
+		var entries []map[string]string
+		for i, c := range acsq {
+			if ai < 0 || c["Allele"] == vepAllele(variant.Reference, variant.Alternate, ai) {
+				idx = append(idx, i)
+				entries = append(entries, c)
+			}
+		}
+		csqs := []map[string]string{nil}
+		severe, canon := -1, -1
+		if len(entries) > 0 {
+			switch t.csq {
+			case "all":
+				csqs = entries
+				severe, canon = csqChoice(entries)
+			case "canonical":
+				csqs[0] = rankCanon(entries)
+			default:
+				csqs[0] = rankSevere(entries)
+			}
+		}
+
+		for ci, csq := range csqs {
+			var row []string
+			for _, c := range cols {
+				switch c.kind {
+				case "CHROM":
+					row = append(row, variant.Chromosome)
+				case "POS":
+					row = append(row, strconv.FormatUint(variant.Pos, 10))
+				case "ID":
+					row = append(row, join([]string{variant.Id_}))
+				case "REF":
+					row = append(row, variant.Reference)
+				case "ALT":
+					row = append(row, join(selectAllele(variant.Alternate, "A", ai)))
+				case "QUAL":
+					row = append(row, strconv.FormatFloat(float64(variant.Quality), 'g', -1, 32))
+				case "FILTER":
+					row = append(row, join([]string{variant.Filter}))
+				case "INFO":
+					val, err := variant.Info().Get(c.key)
+					if err != nil {
+						val = nil
+					}
+					row = append(row, join(selectAllele(infoStrings(val), c.number, ai)))
+				case "FORMAT":
+					for i := 0; i < nsamples; i++ {
+						var vals []string
+						if i < len(variant.Samples) && variant.Samples[i] != nil {
+							if v, ok := sampleField(variant.Samples[i], c.key); ok {
+								vals = strings.Split(v, ",")
+							}
+						}
+						row = append(row, join(selectAllele(vals, c.number, ai)))
+					}
+				case "CSQ":
+					var vals []string
+					if v := csq[c.key]; v != "" {
+						vals = []string{v}
+					}
+					row = append(row, join(vals))
+				}
+			}
+			if t.csq == "all" {
+				if csq == nil {
+					row = append(row, t.missing, t.missing, t.missing)
+				} else {
+					row = append(row, strconv.Itoa(idx[ci]), yesNo(ci == severe), yesNo(ci == canon))
+				}
+			}
+			if err := wrt.Write(row); err != nil {
+				return err
+			}
+			st.RecordsOut++
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/brentp/vcfgo"
+)
+
+func tableHeaderFixture() *vcfgo.Header {
+	return &vcfgo.Header{
+		SampleNames: []string{"proband", "mother"},
+		Infos: map[string]*vcfgo.Info{
+			"AF":  {Id: "AF", Number: "A", Type: "Float"},
+			"DP":  {Id: "DP", Number: "1", Type: "Integer"},
+			"CSQ": {Id: "CSQ", Number: ".", Type: "String", Description: `Consequence annotations from Ensembl VEP. Format: Allele|Consequence|IMPACT|SYMBOL|Feature|CANONICAL"`},
+		},
+		SampleFormats: map[string]*vcfgo.SampleFormat{
+			"GT": {Id: "GT", Number: "1", Type: "String"},
+			"AD": {Id: "AD", Number: "R", Type: "Integer"},
+		},
+	}
+}
+
+// runTable writes vs as toTable would and returns the output lines
+func runTable(t *testing.T, tt *toTable, h *vcfgo.Header, vs ...*vcfgo.Variant) ([]string, *runStats) {
+	t.Helper()
+	if tt.format == "" {
+		tt.format = "tsv"
+	}
+	if tt.missing == "" {
+		tt.missing = "."
+	}
+	if tt.csq == "" {
+		tt.csq = "severe"
+	}
+	cols, err := parseColumns(tt.columns, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var csqKeys []string
+	for _, c := range cols {
+		if c.kind == "CSQ" || tt.csq == "all" {
+			if csqKeys, err = getCSQKeys(h); err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+
+	var b bytes.Buffer
+	wrt, err := tableWriter(&b, tt.format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := &runStats{Counts: map[string]int{}}
+	if err := wrt.Write(tt.header(cols, h.SampleNames)); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range vs {
+		if err := tt.writeRows(wrt, st, v, cols, csqKeys, len(h.SampleNames)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wrt.Flush()
+	return strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n"), st
+}
+
+func checkLines(t *testing.T, name string, got, want []string) {
+	t.Helper()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("%s:\ngot\n%s\nwant\n%s", name, strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestToTableColumns(t *testing.T) {
+	h := tableHeaderFixture()
+	annotated := &vcfgo.Variant{
+		Chromosome: "chr1", Pos: 100, Id_: ".", Reference: "A", Alternate: []string{"G", "T"}, Quality: 50, Filter: "PASS",
+		Info_: testInfo{
+			"AF":  []float64{0.1, 0.2},
+			"CSQ": "G|missense_variant|MODERATE|GENE1|T1|YES,T|stop_gained|HIGH|GENE1|T2|",
+		},
+		Samples: []*vcfgo.SampleGenotype{
+			{Fields: map[string]string{"GT": "0/1", "AD": "10,5,0"}},
+			{GT: []int{0, 0}, Fields: map[string]string{"AD": "20,0,0"}},
+		},
+	}
+	bare := &vcfgo.Variant{
+		Chromosome: "chr2", Pos: 5, Id_: "rs1", Reference: "C", Alternate: []string{"A"}, Quality: 12.5, Filter: ".",
+		Info_:   testInfo{"DP": 30},
+		Samples: []*vcfgo.SampleGenotype{nil, {Phased: true, GT: []int{1, -1}, Fields: map[string]string{}}},
+	}
+
+	lines, st := runTable(t, &toTable{columns: "CHROM,POS,ID,REF,ALT,QUAL,FILTER,AF,INFO/DP,FORMAT/GT,FORMAT/AD,CSQ/SYMBOL,CSQ/Consequence"}, h, annotated, bare)
+	checkLines(t, "tsv", lines, []string{
+		"CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tAF\tDP\tproband:GT\tmother:GT\tproband:AD\tmother:AD\tSYMBOL\tConsequence",
+		"chr1\t100\t.\tA\tG,T\t50\tPASS\t0.1,0.2\t.\t0/1\t0/0\t10,5,0\t20,0,0\tGENE1\tstop_gained",
+		"chr2\t5\trs1\tC\tA\t12.5\t.\t.\t30\t.\t1|.\t.\t.\t.\t.",
+	})
+	if st.RecordsOut != 2 || st.Counts["no_csq"] != 1 {
+		t.Errorf("records out %d, no_csq %d", st.RecordsOut, st.Counts["no_csq"])
+	}
+
+	lines, _ = runTable(t, &toTable{columns: "POS,INFO/DP", missing: "NA"}, h, annotated)
+	checkLines(t, "-missing", lines, []string{"POS\tDP", "100\tNA"})
+}
+
+func TestToTableQuoting(t *testing.T) {
+	h := tableHeaderFixture()
+	v := &vcfgo.Variant{
+		Chromosome: "chr1", Pos: 100, Reference: "A", Alternate: []string{"G", "T"},
+		Info_: testInfo{"AF": "0.1,0.2", "NOTE": `say "hi"`, "TABS": "a\tb"},
+	}
+	for _, tc := range []struct {
+		format string
+		want   []string
+	}{
+		{"tsv", []string{"ALT\tAF\tNOTE\tTABS", "G,T\t0.1,0.2\t\"say \"\"hi\"\"\"\t\"a\tb\""}},
+		{"csv", []string{"ALT,AF,NOTE,TABS", "\"G,T\",\"0.1,0.2\",\"say \"\"hi\"\"\",a\tb"}},
+	} {
+		lines, st := runTable(t, &toTable{columns: "ALT,AF,NOTE,TABS", format: tc.format}, h, v)
+		checkLines(t, tc.format, lines, tc.want)
+		if st.RecordsOut != 1 {
+			t.Errorf("%s: records out %d", tc.format, st.RecordsOut)
+		}
+	}
+
+	if _, err := tableWriter(&bytes.Buffer{}, "xlsx"); err == nil {
+		t.Errorf("xlsx accepted as a table format")
+	}
+}
+
+func TestToTableCSQ(t *testing.T) {
+	h := tableHeaderFixture()
+	// VEP drops the shared first base: A is -, ATT is TT
+	v := &vcfgo.Variant{
+		Chromosome: "chr1", Pos: 100, Reference: "AT", Alternate: []string{"A", "ATT"},
+		Info_: testInfo{"CSQ": []string{
+			"-|frameshift_variant|HIGH|G1|T1|",
+			"-|intron_variant|MODIFIER|G1|T2|YES",
+			"TT|inframe_insertion|MODERATE|G1|T1|",
+			"TT|intron_variant|MODIFIER|G1|T2|YES",
+		}},
+	}
+	for _, tc := range []struct {
+		name string
+		tt   *toTable
+		want []string
+	}{
+		{"severe", &toTable{csq: "severe"}, []string{
+			"A,ATT\tT1\tframeshift_variant",
+		}},
+		{"severe per alt", &toTable{csq: "severe", splitAlts: true}, []string{
+			"A\tT1\tframeshift_variant",
+			"ATT\tT1\tinframe_insertion",
+		}},
+		{"canonical per alt", &toTable{csq: "canonical", splitAlts: true}, []string{
+			"A\tT2\tintron_variant",
+			"ATT\tT2\tintron_variant",
+		}},
+		{"all", &toTable{csq: "all"}, []string{
+			"A,ATT\tT1\tframeshift_variant\t0\t1\t0",
+			"A,ATT\tT2\tintron_variant\t1\t0\t1",
+			"A,ATT\tT1\tinframe_insertion\t2\t0\t0",
+			"A,ATT\tT2\tintron_variant\t3\t0\t0",
+		}},
+		{"all per alt keeps the CSQ index", &toTable{csq: "all", splitAlts: true}, []string{
+			"A\tT1\tframeshift_variant\t0\t1\t0",
+			"A\tT2\tintron_variant\t1\t0\t1",
+			"ATT\tT1\tinframe_insertion\t2\t1\t0",
+			"ATT\tT2\tintron_variant\t3\t0\t1",
+		}},
+	} {
+		tc.tt.columns = "ALT,CSQ/Feature,CSQ/Consequence"
+		lines, st := runTable(t, tc.tt, h, v)
+		checkLines(t, tc.name, lines[1:], tc.want)
+		if st.RecordsOut != len(tc.want) {
+			t.Errorf("%s: records out %d, want %d", tc.name, st.RecordsOut, len(tc.want))
+		}
+	}
+
+	// an alt without entries of its own gets one row of missing CSQ columns
+	v.Alternate = []string{"A", "AG"}
+	lines, _ := runTable(t, &toTable{columns: "ALT,CSQ/Feature", csq: "all", splitAlts: true}, h, v)
+	checkLines(t, "alt without entries", lines, []string{
+		"ALT\tFeature\tCSQ_index\tCSQ_severe\tCSQ_canonical",
+		"A\tT1\t0\t1\t0",
+		"A\tT2\t1\t0\t1",
+		"AG\t.\t.\t.\t.",
+	})
+
+	for ai, want := range map[int]string{0: "-", 1: "G"} {
+		if got := vepAllele("AT", []string{"A", "AG"}, ai); got != want {
+			t.Errorf("vepAllele %d: got %s, want %s", ai, got, want)
+		}
+	}
+	if got := vepAllele("A", []string{"G", "T"}, 1); got != "T" {
+		t.Errorf("vepAllele of a snv: got %s", got)
+	}
+}
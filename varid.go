@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// varID is a variant parsed from a text identifier such as 1-12345-A-G,
+// chr1:12345:A:G or NC_000001.11:g.12345A>G, with an optional sample.
+type varID struct {
+	chrom  string
+	pos    int
+	ref    string
+	alt    string
+	sample string
+}
+
+// varIDFormat is one variant-ID syntax. detect is used for auto-detection,
+// formats without one are only used by name, and key renders a variant back
+// into the same syntax.
+type varIDFormat struct {
+	name   string
+	detect func(s string) bool
+	parse  func(s string) (varID, error)
+	key    func(chrom string, pos int, ref, alt string) string
+}
+
+var (
+	dashVarID       = delimitedVarID("dash", "-")
+	colonVarID      = delimitedVarID("colon", ":")
+	underscoreVarID = delimitedVarID("underscore", "_")
+
+	// gnomAD IDs are dash separated without a chr prefix. They look like dash
+	// IDs, so auto-detection leaves them to dashVarID.
+	gnomadVarID = &varIDFormat{
+		name: "gnomad",
+		parse: func(s string) (varID, error) {
+			id, err := splitVarID(s, "-")
+			id.chrom = strings.TrimPrefix(id.chrom, "chr")
+			return id, err
+		},
+		key: func(chrom string, pos int, ref, alt string) string {
+			return dashVarID.key(strings.TrimPrefix(chrom, "chr"), pos, ref, alt)
+		},
+	}
+
+	hgvsgVarID = &varIDFormat{
+		name:   "hgvs",
+		detect: func(s string) bool { return strings.Contains(s, ":g.") },
+		parse:  parseHGVSg,
+		key:    hgvsgKey,
+	}
+)
+
+// varIDFormats is checked in order when the format is "auto"
+var varIDFormats = []*varIDFormat{
+	hgvsgVarID,
+	colonVarID,
+	underscoreVarID,
+	dashVarID,
+	gnomadVarID,
+}
+
+func varIDFormatNames() []string {
+	names := make([]string, 0, len(varIDFormats))
+	for _, f := range varIDFormats {
+		names = append(names, f.name)
+	}
+	return names
+}
+
+func lookupVarIDFormat(name, s string) (*varIDFormat, error) {
+	for _, f := range varIDFormats {
+		if name == f.name || name == "auto" && f.detect != nil && f.detect(s) {
+			return f, nil
+		}
+	}
+	if name == "auto" {
+		return nil, fmt.Errorf("unrecognised variant id %q", s)
+	}
+	return nil, fmt.Errorf("unknown variant id format %q, expected auto or one of %s", name, strings.Join(varIDFormatNames(), ", "))
+}
+
+// parseVarID parses s with the named format, or with the first format that
+// recognises it when format is "auto". A sample may follow the id after
+// whitespace, or after the alt allele using the id's own separator.
+func parseVarID(s, format string) (varID, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return varID{}, fmt.Errorf("empty variant id")
+	}
+
+	f, err := lookupVarIDFormat(format, fields[0])
+	if err != nil {
+		return varID{}, err
+	}
+
+	id, err := f.parse(fields[0])
+	if err != nil {
+		return id, err
+	}
+	if len(fields) > 1 {
+		id.sample = fields[1]
+	}
+	return id, nil
+}
+
+func delimitedVarID(name, sep string) *varIDFormat {
+	return &varIDFormat{
+		name: name,
+		detect: func(s string) bool {
+			_, err := splitVarID(s, sep)
+			return err == nil
+		},
+		parse: func(s string) (varID, error) {
+			return splitVarID(s, sep)
+		},
+		key: func(chrom string, pos int, ref, alt string) string {
+			return strings.Join([]string{chrom, strconv.Itoa(pos), ref, alt}, sep)
+		},
+	}
+}
+
+var alleleRe = regexp.MustCompile(`^([ACGTNacgtn]+|\*|-|<[^>]+>)$`)
+
+// splitVarID looks for the position as a number followed by two alleles rather
+// than splitting on a fixed field count, so contigs containing the separator
+// (HLA-A*01:01, chrUn_KI270742v1) stay intact. Anything after the alt allele
+// is taken as the sample.
+func splitVarID(s, sep string) (varID, error) {
+	toks := strings.Split(s, sep)
+	for i := 1; i+2 < len(toks); i++ {
+		pos, err := strconv.Atoi(toks[i])
+		if err != nil || pos < 1 {
+			continue
+		}
+		if !alleleRe.MatchString(toks[i+1]) || !alleleRe.MatchString(toks[i+2]) {
+			continue
+		}
+		return varID{
+			chrom:  strings.Join(toks[:i], sep),
+			pos:    pos,
+			ref:    toks[i+1],
+			alt:    toks[i+2],
+			sample: strings.Join(toks[i+3:], sep),
+		}, nil
+	}
+	return varID{}, fmt.Errorf("could not find chrom%[1]spos%[1]sref%[1]salt in %[2]q", sep, s)
+}
+
+var (
+	hgvsgRe       = regexp.MustCompile(`^([^:]+):g\.(\d+)([ACGTN])>([ACGTN])$`)
+	refseqChromRe = regexp.MustCompile(`^NC_0*(\d+)\.\d+$`)
+)
+
+// parseHGVSg reads genomic HGVS substitutions. Other edits need the reference
+// to build an anchored VCF record, see hgvs2vcf.
+func parseHGVSg(s string) (varID, error) {
+	m := hgvsgRe.FindStringSubmatch(s)
+	if m == nil {
+		return varID{}, fmt.Errorf("only substitutions can be read from genomic HGVS without a reference: %q", s)
+	}
+	pos, err := strconv.Atoi(m[2])
+	if err != nil {
+		return varID{}, err
+	}
+	return varID{chrom: refseqChrom(m[1]), pos: pos, ref: m[3], alt: m[4]}, nil
+}
+
+// hgvsgKey writes substitutions as chrom:g.posREF>ALT and anything else as a
+// delins of the reference span
+func hgvsgKey(chrom string, pos int, ref, alt string) string {
+	if len(ref) == 1 && len(alt) == 1 {
+		return fmt.Sprintf("%s:g.%d%s>%s", chrom, pos, ref, alt)
+	}
+	span := strconv.Itoa(pos)
+	if len(ref) > 1 {
+		span += "_" + strconv.Itoa(pos+len(ref)-1)
+	}
+	return fmt.Sprintf("%s:g.%sdelins%s", chrom, span, alt)
+}
+
+// chrName is the chr prefixed name of a contig, MT becoming chrM
+func chrName(chrom string) string {
+	if chrom == "MT" {
+		return "chrM"
+	}
+	if strings.HasPrefix(chrom, "chr") {
+		return chrom
+	}
+	return "chr" + chrom
+}
+
+// refseqChrom maps RefSeq chromosome accessions (NC_000001.11, NC_012920.1) to
+// plain chromosome names, leaving anything else as given.
+func refseqChrom(acc string) string {
+	m := refseqChromRe.FindStringSubmatch(acc)
+	if m == nil {
+		return acc
+	}
+	n, _ := strconv.Atoi(m[1])
+	switch {
+	case n >= 1 && n <= 22:
+		return strconv.Itoa(n)
+	case n == 23:
+		return "X"
+	case n == 24:
+		return "Y"
+	case n == 12920:
+		return "MT"
+	}
+	return acc
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseVarID(t *testing.T) {
+	for _, tc := range []struct {
+		id, format string
+		want       varID
+		err        string
+	}{
+		{"1-100-A-G", "auto", varID{chrom: "1", pos: 100, ref: "A", alt: "G"}, ""},
+		{"1-100-A-G-S1", "auto", varID{chrom: "1", pos: 100, ref: "A", alt: "G", sample: "S1"}, ""},
+		{"1-100-A-G S2", "auto", varID{chrom: "1", pos: 100, ref: "A", alt: "G", sample: "S2"}, ""},
+		{"chr1:100:AT:A", "auto", varID{chrom: "chr1", pos: 100, ref: "AT", alt: "A"}, ""},
+		{"chr1_100_A_<DEL>", "auto", varID{chrom: "chr1", pos: 100, ref: "A", alt: "<DEL>"}, ""},
+		{"chrUn_KI270742v1_100_A_*", "auto", varID{chrom: "chrUn_KI270742v1", pos: 100, ref: "A", alt: "*"}, ""},
+		{"HLA-A*01:01-100-A-G", "auto", varID{chrom: "HLA-A*01:01", pos: 100, ref: "A", alt: "G"}, ""},
+		{"chrX-100-A-G", "gnomad", varID{chrom: "X", pos: 100, ref: "A", alt: "G"}, ""},
+		{"chr1-100-A-G", "dash", varID{chrom: "chr1", pos: 100, ref: "A", alt: "G"}, ""},
+		{"NC_000001.11:g.100A>G", "auto", varID{chrom: "1", pos: 100, ref: "A", alt: "G"}, ""},
+		{"NC_000023.11:g.5C>T S3", "auto", varID{chrom: "X", pos: 5, ref: "C", alt: "T", sample: "S3"}, ""},
+		{"NC_012920.1:g.3243A>G", "hgvs", varID{chrom: "MT", pos: 3243, ref: "A", alt: "G"}, ""},
+		{"NT_187361.1:g.10A>G", "hgvs", varID{chrom: "NT_187361.1", pos: 10, ref: "A", alt: "G"}, ""},
+
+		{"NC_000001.11:g.100_101del", "auto", varID{}, "only substitutions"},
+		{"NC_000001.11:g.100_101insA", "hgvs", varID{}, "only substitutions"},
+		{"1-100-A", "auto", varID{}, "unrecognised variant id"},
+		{"1-0-A-G", "dash", varID{}, "could not find chrom-pos-ref-alt"},
+		{"1-100-A-G", "vcf", varID{}, "unknown variant id format"},
+		{"  ", "auto", varID{}, "empty variant id"},
+	} {
+		got, err := parseVarID(tc.id, tc.format)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s (%s): got %v, want %q", tc.id, tc.format, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s (%s): %s", tc.id, tc.format, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s (%s): got %+v, want %+v", tc.id, tc.format, got, tc.want)
+		}
+	}
+}
+
+func TestVarIDKeys(t *testing.T) {
+	for _, tc := range []struct {
+		f        *varIDFormat
+		chrom    string
+		pos      int
+		ref, alt string
+		want     string
+	}{
+		{dashVarID, "chr1", 100, "A", "G", "chr1-100-A-G"},
+		{colonVarID, "1", 100, "A", "G", "1:100:A:G"},
+		{underscoreVarID, "1", 100, "A", "G", "1_100_A_G"},
+		{gnomadVarID, "chr1", 100, "A", "G", "1-100-A-G"},
+		{hgvsgVarID, "1", 100, "A", "G", "1:g.100A>G"},
+		{hgvsgVarID, "1", 100, "AT", "A", "1:g.100_101delinsA"},
+		{hgvsgVarID, "1", 100, "A", "AT", "1:g.100delinsAT"},
+	} {
+		if got := tc.f.key(tc.chrom, tc.pos, tc.ref, tc.alt); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.f.name, got, tc.want)
+		}
+	}
+
+	for chrom, want := range map[string]string{"1": "chr1", "chr2": "chr2", "MT": "chrM", "X": "chrX"} {
+		if got := chrName(chrom); got != want {
+			t.Errorf("chrName(%s) got %s, want %s", chrom, got, want)
+		}
+	}
+}
@@ -3,10 +3,11 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
 	"os"
+	"reflect"
 	"strconv"
 	"strings"
 
@@ -19,51 +20,62 @@ type manipInfo struct {
 	fields   string
 	prefix   string
 	operator string
+	infos    infoFields
 }
 
 func (*manipInfo) Name() string     { return "manipInfo" }
 func (*manipInfo) Synopsis() string { return "Make new info field based off other fields" }
 func (*manipInfo) Usage() string {
-	return `manipInfo -operator [max,min,mean] -prefix prefix info_field1 info_field2 info_field3`
+	return `manipInfo -operator [max,min,mean] -prefix prefix [-overwrite | -suffix _new] info_field1 info_field2 info_field3`
 }
 
 func (m *manipInfo) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&m.operator, "operator", "", "how to combine fields (max, min, mean)")
 	f.StringVar(&m.prefix, "prefix", "", "prefix of new field being created")
+	m.infos.setFlags(f)
 }
 
-func (m *manipInfo) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+func (m *manipInfo) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := statsFrom(args)
+	ep := errPolicyFrom(args)
 	rdr, err := vcfgo.NewReader(os.Stdin, false)
 	if err != nil {
-		panic(err)
+		return ep.fatal(err)
 	}
 
 	switch m.operator {
-	case "max":
-		rdr.AddInfoToHeader(m.prefix+"_max", "1", "Float", m.prefix+" max")
-		rdr.AddInfoToHeader(m.prefix+"_max_name", "1", "String", "which "+m.prefix+" was the max")
-	case "min":
-		rdr.AddInfoToHeader(m.prefix+"_min", "1", "Float", m.prefix+" min")
-		rdr.AddInfoToHeader(m.prefix+"_min_name", "1", "String", "which "+m.prefix+" was the min")
-	case "mean":
-		rdr.AddInfoToHeader(m.prefix+"_mean", "1", "Float", m.prefix+" mean")
-		rdr.AddInfoToHeader(m.prefix+"_mean_name", "1", "String", "which "+m.prefix+" was the mean")
+	case "max", "min", "mean":
 	default:
-		return subcommands.ExitFailure
+		return ep.usage("-operator must be max, min or mean")
+	}
+	fields := f.Args()
+	valID, nameID := m.prefix+"_"+m.operator, m.prefix+"_"+m.operator+"_name"
+	if err := m.infos.add(rdr, valID, "1", "Float", m.operator+" of "+strings.Join(fields, ",")); err != nil {
+		return ep.fatal(err)
+	}
+	if err := m.infos.add(rdr, nameID, "1", "String", "which of "+strings.Join(fields, ",")+" was the "+m.operator); err != nil {
+		return ep.fatal(err)
 	}
 
+	addProvenance(rdr.Header, ep.cmd)
 	wrt, err := vcfgo.NewWriter(os.Stdout, rdr.Header)
 	if err != nil {
-		panic(err)
+		return ep.fatal(err)
 	}
 
-	fields := f.Args()
-
 	for {
 		variant := rdr.Read()
 		if variant == nil {
 			break
 		}
+		st.RecordsIn++
+		if err := readErr(rdr); err != nil {
+			if !ep.record(variantWhere(variant), err) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+		m.infos.clear(variant)
 
 		var vals []float64
 		var names []string
@@ -109,40 +121,95 @@ func (m *manipInfo) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}
 				outName = "mean"
 			}
 
-			variant.Info().Set(m.prefix+"_"+m.operator, outVal)
-			variant.Info().Set(m.prefix+"_"+m.operator+"_name", outName)
+			variant.Info().Set(m.infos.id(valID), outVal)
+			st.count("set")
+			variant.Info().Set(m.infos.id(nameID), outName)
+			st.RecordsOut++
 			wrt.WriteVariant(variant)
 		} else {
+			st.RecordsOut++
 			wrt.WriteVariant(variant)
 		}
 	}
 	return subcommands.ExitSuccess
 }
 
-type rank struct{}
+type rank struct {
+	rules       string
+	genes       string
+	explain     bool
+	explainJSON string
+	allTiers    bool
+	constraint  string
+	constrained string
+	missingAF   string
+	missing     string
+	unannotated float64
+	gvcf        gvcfBlocks
+	infos       infoFields
+}
 
 func (*rank) Name() string { return "rank" }
 func (*rank) Synopsis() string {
 	return "create new info field with rank of variantMake new info field based off other fields"
 }
 func (*rank) Usage() string {
-	return `rank riskGene1 riskGene2 riskGeneN`
+	return `rank [-rules rules.tsv] [-genes panel.tsv,panel2.tsv] [-explain] [-explain-json why.jsonl] [-all-tiers] [-constraint gnomad.lof_metrics.tsv] [-constrained LOEUF<0.35] [-missing-af rare|common|flag] [-missing dmis=pass,phom=flag] [-unannotated-rank 7] [-gvcf pass|drop] [-overwrite | -suffix _2] riskGene1 riskGene2 riskGeneN`
 }
 
-func (r *rank) SetFlags(f *flag.FlagSet) {}
+func (r *rank) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&r.rules, "rules", "", "tab separated rank and filter expression per line, checked in order before the built in tiers")
+	f.StringVar(&r.genes, "genes", "", "comma sep gene list files (tsv: symbol, hgnc_id, ensembl_gene_id, moi, aliases) used with the risk gene args for tier 1; genes are matched on vep_SYMBOL, vep_HGNC_ID and vep_Gene, falling back to the most severe CSQ entry unless it is only up/downstream or intergenic")
+	f.BoolVar(&r.explain, "explain", false, "add rank_explain listing the criteria met and failed for every tier evaluated")
+	f.StringVar(&r.explainJSON, "explain-json", "", "also write the explanation of every variant to this file, one json object per line")
+	f.BoolVar(&r.allTiers, "all-tiers", false, "check every tier and list all matched ranks in rank_all, rank stays the best")
+	f.StringVar(&r.constraint, "constraint", "", "gnomAD constraint tsv joined on the vep_Feature transcript or its gene, instead of reading gnomAD_pLI")
+	f.StringVar(&r.constrained, "constrained", "", "constraint metric and cutoff, pLI, LOEUF, mis_z or a column of -constraint (default gnomAD_pLI>=0.5, or pLI>=0.5 with -constraint)")
+	f.StringVar(&r.missingAF, "missing-af", "rare", "variants with neither popmax nor TOPMed AF are rare, common, or flag (listed in rank_unannotated and given -unannotated-rank if no tier matches)")
+	f.StringVar(&r.missing, "missing", "", "comma sep predicate=policy for checks whose fields are all missing, predicates af, dmis (CADD_phred, REVEL_score), splice (spliceAI_max), constraint, phom, pchet and policies pass, fail, flag (default af=pass, the rest fail)")
+	f.Float64Var(&r.unannotated, "unannotated-rank", 7.0, "rank and comphet_rank given to variants no tier matched that had a flagged predicate missing its fields")
+	r.gvcf.setFlags(f)
+	r.infos.setFlags(f)
+}
 
-func isDmis(v *vcfgo.Variant) bool {
-	revelI, _ := v.Info().Get("REVEL_score")
-	revel, ok := revelI.(float64)
-	if !ok {
-		revel = 0.0
-	}
+// ranker holds what the tiers need besides the variant. ex is only set
+// when rank explains its decisions.
+type ranker struct {
+	panel       *genePanel
+	csqKeys     []string
+	constraint  *constraintTable
+	constrained constraintCutoff
+	missing     missingPolicy
+	flagged     []string
+	ex          *explanation
+}
 
-	caddI, _ := v.Info().Get("CADD_phred")
-	cadd, ok := caddI.(float64)
-	if !ok {
-		cadd = 0.0
-	}
+// tier runs one group as a named step of the explanation
+func (rk *ranker) tier(name string, v *vcfgo.Variant, group func(*ranker, *vcfgo.Variant) bool) bool {
+	rk.ex.begin(name)
+	return rk.ex.end(group(rk, v))
+}
+
+// rankTiers are the built in tiers in the order rank checks them
+var rankTiers = []struct {
+	rank  float64
+	name  string
+	group func(*ranker, *vcfgo.Variant) bool
+}{
+	{0.5, "clinvar", (*ranker).groupClinVar},
+	{1.0, "tier1", (*ranker).groupOne},
+	{2.0, "tier2", (*ranker).groupTwo},
+	{2.5, "tier2.5", (*ranker).groupTwoPointFive},
+	{3.0, "tier3", (*ranker).groupThree},
+	{4.0, "tier4", (*ranker).groupFour},
+	{5.0, "tier5", (*ranker).groupFive},
+	{5.5, "tier5.5", (*ranker).groupFivePointFive},
+	{6.0, "tier6", (*ranker).groupSix},
+}
+
+func (rk *ranker) isDmis(v *vcfgo.Variant) bool {
+	revel, okRevel := infoNumber(v, "REVEL_score")
+	cadd, okCADD := infoNumber(v, "CADD_phred")
 
 	del := cadd >= 25.0 || revel >= 0.5
 
@@ -152,35 +219,43 @@ func isDmis(v *vcfgo.Variant) bool {
 		csq = "."
 	}
 
+	detail := "vep_Consequence=" + csq + "&" + cmpDetail("CADD_phred", shown(v, "CADD_phred"), cadd, 25.0) +
+		"&" + cmpDetail("REVEL_score", shown(v, "REVEL_score"), revel, 0.5)
+	if csq == "missense_variant" && !okRevel && !okCADD {
+		del = rk.missingPass("dmis")
+	}
 	if csq == "missense_variant" && del {
-		return true
+		return rk.ex.note("isDmis", true, detail)
 	}
-	return false
+	return rk.ex.note("isDmis", false, detail)
 }
 
-func isLGD(v *vcfgo.Variant) bool {
+func (rk *ranker) isLGD(v *vcfgo.Variant) bool {
 	impactI, _ := v.Info().Get("vep_IMPACT")
 	impact, ok := impactI.(string)
 	if !ok {
 		impact = "."
 	}
 	if impact == "HIGH" {
-		return true
+		return rk.ex.note("isLGD", true, "vep_IMPACT="+impact)
 	}
-	return false
+	return rk.ex.note("isLGD", false, "vep_IMPACT="+impact)
 }
 
-func isConstrained(v *vcfgo.Variant) bool {
-	pliI, _ := v.Info().Get("gnomAD_pLI")
-	pli, ok := pliI.(float64)
-	if !ok {
-		pli = 0.0
+// isConstrained tests gnomAD_pLI >= 0.5 unless rank was given another
+// cutoff or a constraint table
+func (rk *ranker) isConstrained(v *vcfgo.Variant) bool {
+	c := rk.constrained
+	if c.metric == "" {
+		c = defaultConstrained
 	}
+	x, s, ok := rk.constraintValue(v, c)
 
-	if pli >= 0.5 {
-		return true
+	detail := cmpDetail(c.metric, s, x, c.cut)
+	if ok && c.test(x) || !ok && rk.missingPass("constraint") {
+		return rk.ex.note("isConstrained", true, detail)
 	}
-	return false
+	return rk.ex.note("isConstrained", false, detail)
 }
 
 func getGnomAD(v *vcfgo.Variant) float64 {
@@ -197,23 +272,26 @@ func getGnomAD(v *vcfgo.Variant) float64 {
 	return gnomadAF
 }
 
-func isRare(v *vcfgo.Variant) bool {
-	gnomadAF := getGnomAD(v)
+// uncommon notes whether the gnomAD popmax AF is below cut
+func (rk *ranker) uncommon(v *vcfgo.Variant, cut float64) bool {
+	gnomadAF, _ := rk.popAFs(v)
+	k, s := gnomADDetail(v)
+	return rk.ex.note("uncommon", gnomadAF < cut, cmpDetail(k, s, gnomadAF, cut))
+}
 
-	topMedI, _ := v.Info().Get("TOPMed_AF")
-	topMed, ok := topMedI.(float64)
-	if !ok {
-		topMed = 0.0
-	}
+func (rk *ranker) isRare(v *vcfgo.Variant) bool {
+	gnomadAF, topMed := rk.popAFs(v)
 
+	k, s := gnomADDetail(v)
+	detail := cmpDetail(k, s, gnomadAF, 0.0001) + "&" + cmpDetail("TOPMed_AF", shown(v, "TOPMed_AF"), topMed, 0.001)
 	if gnomadAF <= 0.0001 && topMed < 0.001 {
-		return true
+		return rk.ex.note("isRare", true, detail)
 	}
 
-	return false
+	return rk.ex.note("isRare", false, detail)
 }
 
-func isSpliceDamage(v *vcfgo.Variant) bool {
+func (rk *ranker) isSpliceDamage(v *vcfgo.Variant) bool {
 	//impactI, _ := v.Info().Get("vep_IMPACT")
 	//impact, ok := impactI.(string)
 	//if !ok {
@@ -221,42 +299,52 @@ func isSpliceDamage(v *vcfgo.Variant) bool {
 	//}
 
 	//if impact == "MODIFIER" || impact == "LOW" {
-	maxI, _ := v.Info().Get("spliceAI_max")
-	max, ok := maxI.(float64)
-	if !ok {
-		max = 0.0
-	}
+	max, ok := infoNumber(v, "spliceAI_max")
 
-	if max >= 0.2 {
-		return true
+	detail := cmpDetail("spliceAI_max", shown(v, "spliceAI_max"), max, 0.2)
+	if ok && max >= 0.2 || !ok && rk.missingPass("splice") {
+		return rk.ex.note("isSpliceDamage", true, detail)
 	}
 	//}
-	return false
+	return rk.ex.note("isSpliceDamage", false, detail)
 }
 
-func groupOne(v *vcfgo.Variant, riskGenes []string) bool {
-	if isDmis(v) || isLGD(v) {
-		if isRare(v) {
-			geneI, _ := v.Info().Get("vep_SYMBOL")
-			gene, ok := geneI.(string)
-			if !ok {
-				gene = "."
-			}
+// isRecessive notes whether slivar called the variant recessive or x_recessive
+func (rk *ranker) isRecessive(v *vcfgo.Variant) bool {
+	r := true
+	recI, _ := v.Info().Get("recessive")
+	if recI == nil {
+		r = false
+	}
 
-			for _, rGene := range riskGenes {
-				if gene == rGene {
-					return true
-				}
+	xr := true
+	xrecI, _ := v.Info().Get("x_recessive")
+	if xrecI == nil {
+		xr = false
+	}
+
+	return rk.ex.note("isRecessive", r || xr, fmt.Sprintf("recessive=%t&x_recessive=%t", r, xr))
+}
+
+func (rk *ranker) groupOne(v *vcfgo.Variant) bool {
+	if rk.isDmis(v) || rk.isLGD(v) {
+		if rk.isRare(v) {
+			gene := rk.panel.match(v)
+			if !rk.ex.note("inPanel", gene != nil, "") {
+				return false
 			}
+			// recessive only genes need a recessive or comp het call
+			return rk.ex.note("moi", gene.dominant || gene.recessive && hasRecessiveGenotype(v),
+				fmt.Sprintf("gene=%s&dominant=%t&recessive=%t", gene.symbol, gene.dominant, gene.recessive))
 		}
 	}
 	return false
 }
 
-func groupTwo(v *vcfgo.Variant) bool {
-	if isLGD(v) {
-		if isRare(v) {
-			if isConstrained(v) {
+func (rk *ranker) groupTwo(v *vcfgo.Variant) bool {
+	if rk.isLGD(v) {
+		if rk.isRare(v) {
+			if rk.isConstrained(v) {
 				return true
 			}
 		}
@@ -264,10 +352,10 @@ func groupTwo(v *vcfgo.Variant) bool {
 	return false
 }
 
-func groupTwoPointFive(v *vcfgo.Variant) bool {
-	if isDmis(v) || isSpliceDamage(v) {
-		if isRare(v) {
-			if isConstrained(v) {
+func (rk *ranker) groupTwoPointFive(v *vcfgo.Variant) bool {
+	if rk.isDmis(v) || rk.isSpliceDamage(v) {
+		if rk.isRare(v) {
+			if rk.isConstrained(v) {
 				return true
 			}
 		}
@@ -275,38 +363,21 @@ func groupTwoPointFive(v *vcfgo.Variant) bool {
 	return false
 }
 
-func groupThree(v *vcfgo.Variant) bool {
-	gnomadAF := getGnomAD(v)
+func (rk *ranker) groupThree(v *vcfgo.Variant) bool {
+	gnomadAF, topMed := rk.popAFs(v)
 
-	topMedI, _ := v.Info().Get("TOPMed_AF")
-	topMed, ok := topMedI.(float64)
-	if !ok {
-		topMed = 0.0
-	}
-
-	if gnomadAF > 0.01 || topMed > 0.01 {
+	k, s := gnomADDetail(v)
+	if !rk.ex.note("notCommon", !(gnomadAF > 0.01 || topMed > 0.01),
+		cmpDetail(k, s, gnomadAF, 0.01)+"&"+cmpDetail("TOPMed_AF", shown(v, "TOPMed_AF"), topMed, 0.01)) {
 		return false
 	}
 
-	r := true
-	recI, _ := v.Info().Get("recessive")
-	if recI == nil {
-		r = false
-	}
-
-	xr := true
-	xrecI, _ := v.Info().Get("x_recessive")
-	if xrecI == nil {
-		xr = false
-	}
-
-	if r || xr {
-		phomI, _ := v.Info().Get("phom")
-		phom, ok := phomI.(float64)
+	if rk.isRecessive(v) {
+		phom, ok := infoNumber(v, "phom")
 		if !ok {
 			phom = 1.0
 		}
-		if phom < 0.002 {
+		if rk.ex.note("phom", ok && phom < 0.002 || !ok && rk.missingPass("phom"), cmpDetail("phom", shown(v, "phom"), phom, 0.002)) {
 			return true
 		}
 	}
@@ -314,26 +385,28 @@ func groupThree(v *vcfgo.Variant) bool {
 	return false
 }
 
-func groupFour(v *vcfgo.Variant) bool {
-	if isDmis(v) || isLGD(v) || isSpliceDamage(v) {
-		if isRare(v) {
+func (rk *ranker) groupFour(v *vcfgo.Variant) bool {
+	if rk.isDmis(v) || rk.isLGD(v) || rk.isSpliceDamage(v) {
+		if rk.isRare(v) {
 			return true
 		}
 	}
 	return false
 }
 
-func groupFive(v *vcfgo.Variant) bool {
+func (rk *ranker) groupFive(v *vcfgo.Variant) bool {
 	csqI, _ := v.Info().Get("vep_Consequence")
 	csq, ok := csqI.(string)
 	if !ok {
 		csq = "."
 	}
 
-	if isLGD(v) || csq == "missense_variant" || isSpliceDamage(v) {
-		gnomadAF := getGnomAD(v)
+	if rk.isLGD(v) || rk.ex.note("missense", csq == "missense_variant", "vep_Consequence="+csq) || rk.isSpliceDamage(v) {
+		gnomadAF, _ := rk.popAFs(v)
 
-		if gnomadAF <= 0.001 && gnomadAF >= 0.0001 {
+		k, s := gnomADDetail(v)
+		if rk.ex.note("lowFrequency", gnomadAF <= 0.001 && gnomadAF >= 0.0001,
+			cmpDetail(k, s, gnomadAF, 0.0001)+"&"+cmpDetail(k, s, gnomadAF, 0.001)) {
 			return true
 		}
 	}
@@ -341,48 +414,35 @@ func groupFive(v *vcfgo.Variant) bool {
 	return false
 }
 
-func groupFivePointFive(v *vcfgo.Variant) bool {
+func (rk *ranker) groupFivePointFive(v *vcfgo.Variant) bool {
 	dnvI, _ := v.Info().Get("denovo")
 	_, ok := dnvI.(string)
-	if ok {
+	if rk.ex.note("denovo", ok, "") {
 		return true
 	}
 	hqdnvI, _ := v.Info().Get("hq_denovo")
 	_, ok = hqdnvI.(string)
-	if ok {
+	if rk.ex.note("hq_denovo", ok, "") {
 		return true
 	}
 	return false
 }
 
 
-func groupSix(v *vcfgo.Variant) bool {
-	r := true
-	recI, _ := v.Info().Get("recessive")
-	if recI == nil {
-		r = false
-	}
-
-	xr := true
-	xrecI, _ := v.Info().Get("x_recessive")
-	if xrecI == nil {
-		xr = false
-	}
-
-	if r || xr {
-		gnomadAF := getGnomAD(v)
-		if gnomadAF < 0.01 {
-			if isDmis(v) || isSpliceDamage(v) || isLGD(v) {
+func (rk *ranker) groupSix(v *vcfgo.Variant) bool {
+	if rk.isRecessive(v) {
+		if rk.uncommon(v, 0.01) {
+			if rk.isDmis(v) || rk.isSpliceDamage(v) || rk.isLGD(v) {
 				return true
 			}
 		}
 
-		phomI, _ := v.Info().Get("phom")
-		phom, ok := phomI.(float64)
+		phom, ok := infoNumber(v, "phom")
 		if !ok {
 			phom = 1.0
 		}
-		if phom < 0.05 && phom >= 0.002 {
+		if rk.ex.note("phom", ok && phom < 0.05 && phom >= 0.002 || !ok && rk.missingPass("phom"),
+			cmpDetail("phom", shown(v, "phom"), phom, 0.002)+"&"+cmpDetail("phom", shown(v, "phom"), phom, 0.05)) {
 			return true
 		}
 	}
@@ -390,16 +450,15 @@ func groupSix(v *vcfgo.Variant) bool {
 	return false
 }
 
-func groupThreeCompHet(v *vcfgo.Variant) bool {
+func (rk *ranker) groupThreeCompHet(v *vcfgo.Variant) bool {
 	chI, _ := v.Info().Get("slivar_comphet")
-	if chI != nil {
-		pchetI, _ := v.Info().Get("pchet")
-		pchet, ok := pchetI.(float64)
+	if rk.ex.note("comphet", chI != nil, "") {
+		pchet, ok := infoNumber(v, "pchet")
 		if !ok {
 			pchet = 1.0
 		}
 
-		if pchet < 0.002 {
+		if rk.ex.note("pchet", ok && pchet < 0.002 || !ok && rk.missingPass("pchet"), cmpDetail("pchet", shown(v, "pchet"), pchet, 0.002)) {
 			return true
 		}
 	}
@@ -407,23 +466,22 @@ func groupThreeCompHet(v *vcfgo.Variant) bool {
 	return false
 }
 
-func groupSixCompHet(v *vcfgo.Variant) bool {
+func (rk *ranker) groupSixCompHet(v *vcfgo.Variant) bool {
 	chI, _ := v.Info().Get("slivar_comphet")
-	if chI != nil {
-		pchetI, _ := v.Info().Get("pchet")
-		pchet, ok := pchetI.(float64)
+	if rk.ex.note("comphet", chI != nil, "") {
+		pchet, ok := infoNumber(v, "pchet")
 		if !ok {
 			pchet = 1.0
 		}
 
-		if pchet < 0.05 && pchet >= 0.002 {
+		if rk.ex.note("pchet", ok && pchet < 0.05 && pchet >= 0.002 || !ok && rk.missingPass("pchet"),
+			cmpDetail("pchet", shown(v, "pchet"), pchet, 0.002)+"&"+cmpDetail("pchet", shown(v, "pchet"), pchet, 0.05)) {
 			return true
 		}
 
 
-		gnomadAF := getGnomAD(v)
-		if gnomadAF < 0.01 {
-			if isDmis(v) || isSpliceDamage(v) || isLGD(v) {
+		if rk.uncommon(v, 0.01) {
+			if rk.isDmis(v) || rk.isSpliceDamage(v) || rk.isLGD(v) {
 				return true
 			}
 		}
@@ -432,73 +490,218 @@ func groupSixCompHet(v *vcfgo.Variant) bool {
 	return false
 }
 
-func (r *rank) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
-	riskGenes := f.Args()
-
+func (r *rank) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := statsFrom(args)
+	ep := errPolicyFrom(args)
 	rdr, err := vcfgo.NewReader(os.Stdin, false)
 	if err != nil {
-		fmt.Println(err)
-		return subcommands.ExitFailure
+		return ep.fatal(err)
+	}
+
+	panel := newGenePanel()
+	for _, g := range f.Args() {
+		panel.add(&panelGene{symbol: g, dominant: true, recessive: true}, g)
+	}
+	if r.genes != "" {
+		for _, path := range strings.Split(r.genes, ",") {
+			if err := panel.readGenePanel(path); err != nil {
+				return ep.fatal(err)
+			}
+		}
+	}
+	// CSQ is optional, genes are then matched on the vep_ INFO fields only
+	panel.csqKeys, _ = getCSQKeys(rdr.Header)
+
+	var rules []rankRule
+	if r.rules != "" {
+		rules, err = readRankRules(r.rules, rdr.Header)
+		if err != nil {
+			return ep.fatal(err)
+		}
+	}
+
+	rk := &ranker{panel: panel, csqKeys: panel.csqKeys}
+	if rk.missing, err = parseMissingPolicy(r.missingAF, r.missing); err != nil {
+		return ep.usage(err.Error())
+	}
+	if err := r.gvcf.begin(rdr.Header, ep.cmd); err != nil {
+		return ep.usage(err.Error())
+	}
+	if r.constrained == "" && r.constraint != "" {
+		r.constrained = "pLI>=0.5"
+	}
+	if r.constrained != "" {
+		if rk.constrained, err = parseConstraintCutoff(r.constrained); err != nil {
+			return ep.fatal(err)
+		}
+	}
+	if r.constraint != "" {
+		if rk.constraint, err = readConstraintTable(r.constraint, rk.constrained.metric); err != nil {
+			return ep.fatal(err)
+		}
 	}
 
-	rdr.AddInfoToHeader("rank", "1", "Float", "variant classifications")
-	rdr.AddInfoToHeader("comphet_rank", "1", "Float", "variant classifications for half of compound het")
+	for _, h := range []struct {
+		add                   bool
+		id, number, typ, desc string
+	}{
+		{true, "rank", "1", "Float", "variant classifications"},
+		{true, "comphet_rank", "1", "Float", "variant classifications for half of compound het"},
+		{r.allTiers, "rank_all", ".", "String", "every rule and tier the variant matched, best first in check order"},
+		{rk.missing.flags(), "rank_unannotated", ".", "String", "flagged rank predicates whose fields were all missing (af, dmis, splice, constraint, phom, pchet)"},
+		{r.explain, "rank_explain", ".", "String", "criteria checked by each rank tier evaluated, tier=matched:criterion=met(values)|... with = ; , and % percent-encoded"},
+	} {
+		if !h.add {
+			continue
+		}
+		if err := r.infos.add(rdr, h.id, h.number, h.typ, h.desc); err != nil {
+			return ep.fatal(err)
+		}
+	}
 
+	var explainOut *json.Encoder
+	if r.explainJSON != "" {
+		file, err := os.Create(r.explainJSON)
+		if err != nil {
+			return ep.fatal(err)
+		}
+		defer file.Close()
+		explainOut = json.NewEncoder(file)
+		explainOut.SetEscapeHTML(false)
+	}
+
+	addProvenance(rdr.Header, ep.cmd)
 	wrt, err := vcfgo.NewWriter(os.Stdout, rdr.Header)
 	if err != nil {
-		fmt.Println(err)
-		return subcommands.ExitFailure
+		return ep.fatal(err)
 	}
 
+	missing := newMissingCounts(rk)
 	for {
 		variant := rdr.Read()
 		if variant == nil {
 			break
 		}
+		st.RecordsIn++
+		if err := readErr(rdr); err != nil {
+			if !ep.record(variantWhere(variant), err) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+		if r.gvcf.skip(variant, wrt, st) {
+			continue
+		}
+		r.infos.clear(variant)
+
+		missing.add(rk, variant)
+
+		rk.ex = nil
+		rk.flagged = nil
+		if r.explain || explainOut != nil {
+			rk.ex = &explanation{Variant: getVarID(variant)}
+		}
 
+		// rank is the first match, with -all-tiers every tier is checked
 		var rank float64
-		switch {
-		case groupOne(variant, riskGenes):
-			rank = 1.0
-		case groupTwo(variant):
-			rank = 2.0
-		case groupTwoPointFive(variant):
-			rank = 2.5
-		case groupThree(variant):
-			rank = 3.0
-		case groupFour(variant):
-			rank = 4.0
-		case groupFive(variant):
-			rank = 5.0
-		case groupFivePointFive(variant):
-			rank = 5.5
-		case groupSix(variant):
-			rank = 6.0
+		var all []string
+		matched := func(x float64) {
+			if rank == 0.0 {
+				rank = x
+			}
+			for _, a := range all {
+				if a == fmtNum(x) {
+					return
+				}
+			}
+			all = append(all, fmtNum(x))
+		}
+		for _, rule := range rules {
+			if rank != 0.0 && !r.allTiers {
+				break
+			}
+			rk.ex.begin("rule" + fmtNum(rule.rank))
+			if rk.ex.end(rk.ex.note("expr", rule.expr.match(variant), rule.expr.src)) {
+				matched(rule.rank)
+			}
 		}
+		for _, t := range rankTiers {
+			if rank != 0.0 && !r.allTiers {
+				break
+			}
+			if rk.tier(t.name, variant, t.group) {
+				matched(t.rank)
+			}
+		}
+		if rank == 0.0 && rk.unannotated() {
+			matched(r.unannotated)
+		}
+		unannotated := rk.flagged
+		rk.flagged = nil
 
 		if rank != 0.0 {
-			variant.Info().Set("rank", rank)
+			variant.Info().Set(r.infos.id("rank"), rank)
+			st.count("tier " + fmtNum(rank))
+		} else {
+			st.count("unranked")
+		}
+		if r.allTiers && len(all) > 0 {
+			variant.Info().Set(r.infos.id("rank_all"), strings.Join(all, ","))
 		}
 
 		var rankCompHet float64
 		switch {
-		case groupThreeCompHet(variant):
+		case rk.tier("comphet3", variant, (*ranker).groupThreeCompHet):
 			rankCompHet = 3.0
-		case groupSixCompHet(variant):
+		case rk.tier("comphet6", variant, (*ranker).groupSixCompHet):
 			rankCompHet = 6.0
 		}
+		if rankCompHet == 0.0 && rk.unannotated() {
+			rankCompHet = r.unannotated
+		}
+		seen := map[string]bool{}
+		for _, p := range unannotated {
+			seen[p] = true
+		}
+		for _, p := range rk.flagged {
+			if !seen[p] {
+				unannotated = append(unannotated, p)
+			}
+		}
+		if len(unannotated) > 0 {
+			variant.Info().Set(r.infos.id("rank_unannotated"), strings.Join(unannotated, ","))
+			st.count("unannotated")
+		}
 
 		if rankCompHet != 0.0 {
-			variant.Info().Set("comphet_rank", rankCompHet)
+			variant.Info().Set(r.infos.id("comphet_rank"), rankCompHet)
+			st.count("comphet tier " + fmtNum(rankCompHet))
+		}
+
+		if rk.ex != nil {
+			rk.ex.Rank, rk.ex.CompHetRank = rank, rankCompHet
+			if r.explain {
+				variant.Info().Set(r.infos.id("rank_explain"), rk.ex.info())
+			}
+			if explainOut != nil {
+				if err := explainOut.Encode(rk.ex); err != nil {
+					return ep.fatal(err)
+				}
+			}
 		}
 
+		st.RecordsOut++
 		wrt.WriteVariant(variant)
 	}
+	missing.summary(os.Stderr)
+	for f, n := range missing.counts {
+		st.Counts["missing "+f] = n
+	}
 	return subcommands.ExitSuccess
 }
 
 func getVarID(v *vcfgo.Variant) string {
-	return v.Chromosome + "-" + strconv.Itoa(int(v.Pos)) + "-" + v.Reference + "-" + v.Alternate[0]
+	return dashVarID.key(v.Chromosome, int(v.Pos), v.Reference, v.Alternate[0])
 }
 
 type filterCompHet struct {}
@@ -513,18 +716,28 @@ func (*filterCompHet) Usage() string {
 
 func (fch *filterCompHet) SetFlags(f *flag.FlagSet) {}
 
-func (fch *filterCompHet) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+// compHetID is the pair id, the third field of a slivar_comphet value
+func compHetID(s string) (string, error) {
+	ls := strings.Split(s, "/")
+	if len(ls) < 3 {
+		return "", fmt.Errorf("malformed slivar_comphet %q", s)
+	}
+	return ls[2], nil
+}
+
+func (fch *filterCompHet) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := statsFrom(args)
+	ep := errPolicyFrom(args)
 
 	rdr, err := vcfgo.NewReader(os.Stdin, false)
 	if err != nil {
-		fmt.Println(err)
-		return subcommands.ExitFailure
+		return ep.fatal(err)
 	}
 
+	addProvenance(rdr.Header, ep.cmd)
 	wrt, err := vcfgo.NewWriter(os.Stdout, rdr.Header)
 	if err != nil {
-		fmt.Println(err)
-		return subcommands.ExitFailure
+		return ep.fatal(err)
 	}
 
 	type compHetVariant struct {
@@ -544,15 +757,32 @@ func (fch *filterCompHet) Execute(_ context.Context, f *flag.FlagSet, _ ...inter
 		if variant == nil {
 			break
 		}
+		st.RecordsIn++
+		if err := readErr(rdr); err != nil {
+			if !ep.record(variantWhere(variant), err) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
 
 		_, err := variant.Info().Get("comphet_rank")
 		if err == nil {
+			st.count("comphet_ranked")
 			chI, err := variant.Info().Get("slivar_comphet")
 			if err != nil {
-				panic("should be a slivar compound het vcf, i.e. all variants should have info field 'slivar_comphet'")
+				if !ep.record(variantWhere(variant), fmt.Errorf("should be a slivar compound het vcf, i.e. all variants should have info field 'slivar_comphet'")) {
+					return subcommands.ExitFailure
+				}
+				continue
 			}
 			if chString, ok := chI.(string); ok {
-				chId := strings.Split(chString, "/")[2]
+				chId, err := compHetID(chString)
+				if err != nil {
+					if !ep.record(variantWhere(variant), err) {
+						return subcommands.ExitFailure
+					}
+					continue
+				}
 				if pair, ok := compHetMap[chId]; ok {
 					pair.ch2 = compHetVariant{
 						variant:   variant,
@@ -572,7 +802,13 @@ func (fch *filterCompHet) Execute(_ context.Context, f *flag.FlagSet, _ ...inter
 			} else {
 				if chSlice, ok := chI.([]string); ok {
 					for _, chString := range chSlice {
-						chId := strings.Split(chString, "/")[2]
+						chId, err := compHetID(chString)
+						if err != nil {
+							if !ep.record(variantWhere(variant), err) {
+								return subcommands.ExitFailure
+							}
+							continue
+						}
 						if pair, ok := compHetMap[chId]; ok {
 							pair.ch2 = compHetVariant{
 								variant:   variant,
@@ -595,9 +831,11 @@ func (fch *filterCompHet) Execute(_ context.Context, f *flag.FlagSet, _ ...inter
 		}
 	}
 
+	st.Counts["pairs_found"] = len(compHetMap)
 	toWrite := map[string]*vcfgo.Variant{}
 	for _, p := range compHetMap {
 		if p.paired {
+			st.count("pairs_kept")
 			v1Id := getVarID(p.ch1.variant)
 			v2Id := getVarID(p.ch2.variant)
 			if _, ok := toWrite[v1Id]; ok {
@@ -618,6 +856,7 @@ func (fch *filterCompHet) Execute(_ context.Context, f *flag.FlagSet, _ ...inter
 	}
 
 	for _, v := range toWrite {
+		st.RecordsOut++
 		wrt.WriteVariant(v)
 	}
 
@@ -650,15 +889,17 @@ func (p *psap2vcf) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&p.vcf, "vcf", "", "output vcf")
 }
 
-func (p *psap2vcf) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+func (p *psap2vcf) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := statsFrom(args)
+	ep := errPolicyFrom(args)
 	file, err := os.Open(p.txt)
 	if err != nil {
-		panic(err)
+		return ep.fatal(err)
 	}
 
 	defer file.Close()
 
-	psapM := make(map[string]*popscores)
+	psapM := make(map[varID]*popscores)
 
 	scanner := bufio.NewScanner(file)
 
@@ -676,12 +917,28 @@ func (p *psap2vcf) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{})
 	}
 
 	if modelIdx == 0 {
-		panic("could not find proband column using supplied proband name")
+		return ep.fatal(fmt.Errorf("%s: could not find proband column using supplied proband name", p.txt))
 	}
 
+	n := 1
 	for scanner.Scan() {
+		n++
 		ls := strings.Split(scanner.Text(), "\t")
-		vID := ls[0] + "-" + ls[1] + "-" + ls[3] + "-" + ls[4]
+		st.RecordsIn++
+		if len(ls) <= modelIdx || len(ls) <= scoreIdx || len(ls) < 5 {
+			if !ep.record(lineWhere(p.txt, n), fmt.Errorf("expected at least %d columns, found %d", modelIdx+1, len(ls))) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+		pos, err := strconv.Atoi(ls[1])
+		if err != nil {
+			if !ep.record(lineWhere(p.txt, n), fmt.Errorf("bad position %q", ls[1])) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+		vID := varID{chrom: ls[0], pos: pos, ref: ls[3], alt: ls[4]}
 
 		pType := ls[modelIdx]
 		score := ls[scoreIdx]
@@ -690,19 +947,28 @@ func (p *psap2vcf) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{})
 			case "DOM-het":
 				dom, err := strconv.ParseFloat(score, 64)
 				if err != nil {
-					panic(err)
+					if !ep.record(lineWhere(p.txt, n), err) {
+						return subcommands.ExitFailure
+					}
+					continue
 				}
 				psapM[vID].dom = &dom
 			case "REC-hom":
 				rec, err := strconv.ParseFloat(score, 64)
 				if err != nil {
-					panic(err)
+					if !ep.record(lineWhere(p.txt, n), err) {
+						return subcommands.ExitFailure
+					}
+					continue
 				}
 				psapM[vID].rec = &rec
 			case "REC-chet":
 				chet, err := strconv.ParseFloat(score, 64)
 				if err != nil {
-					panic(err)
+					if !ep.record(lineWhere(p.txt, n), err) {
+						return subcommands.ExitFailure
+					}
+					continue
 				}
 				psapM[vID].chet = &chet
 			}
@@ -712,19 +978,28 @@ func (p *psap2vcf) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{})
 			case "DOM-het":
 				dom, err := strconv.ParseFloat(score, 64)
 				if err != nil {
-					panic(err)
+					if !ep.record(lineWhere(p.txt, n), err) {
+						return subcommands.ExitFailure
+					}
+					continue
 				}
 				pops.dom = &dom
 			case "REC-hom":
 				rec, err := strconv.ParseFloat(score, 64)
 				if err != nil {
-					panic(err)
+					if !ep.record(lineWhere(p.txt, n), err) {
+						return subcommands.ExitFailure
+					}
+					continue
 				}
 				pops.rec = &rec
 			case "REC-chet":
 				chet, err := strconv.ParseFloat(score, 64)
 				if err != nil {
-					panic(err)
+					if !ep.record(lineWhere(p.txt, n), err) {
+						return subcommands.ExitFailure
+					}
+					continue
 				}
 				pops.chet = &chet
 			}
@@ -754,22 +1029,18 @@ func (p *psap2vcf) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{})
 		Type:        "Float",
 	}
 
+	addProvenance(hdr, ep.cmd)
 	wrt, err := vcfgo.NewWriter(os.Stdout, hdr)
 	if err != nil {
-		fmt.Println(err)
-		return subcommands.ExitFailure
+		return ep.fatal(err)
 	}
 
 
-	for k, v := range psapM {
-		vID := strings.Split(k, "-")
-		chrom := vID[0]
-		pos, err := strconv.Atoi(vID[1])
-		if err != nil {
-			panic(err)
-		}
-		ref := vID[2]
-		alt := vID[3]
+	for vID, v := range psapM {
+		chrom := vID.chrom
+		pos := vID.pos
+		ref := vID.ref
+		alt := vID.alt
 
 		var variant *vcfgo.Variant
 
@@ -793,6 +1064,7 @@ func (p *psap2vcf) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{})
 		if v.chet != nil {
 			_ = variant.Info().Set("pchet", *v.chet)
 		}
+		st.RecordsOut++
 		wrt.WriteVariant(variant)
 	}
 	return subcommands.ExitSuccess
@@ -800,6 +1072,8 @@ func (p *psap2vcf) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{})
 
 type coords struct {
 	label string
+	infos infoFields
+	gvcf  gvcfBlocks
 }
 
 func (*coords) Name() string { return "coords" }
@@ -807,26 +1081,38 @@ func (*coords) Synopsis() string {
 	return "add coordinates of variant to info field"
 }
 func (*coords) Usage() string {
-	return `coords`
+	return `coords -label hg19 [-overwrite | -suffix _new] [-gvcf pass|drop]`
 }
 
 func (c *coords) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&c.label, "label", "", "label of coords i.e. hg19 -> hg19_pos")
+	c.infos.setFlags(f)
+	c.gvcf.setFlags(f)
 }
 
-func (c *coords) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+func (c *coords) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := statsFrom(args)
+	ep := errPolicyFrom(args)
 	rdr, err := vcfgo.NewReader(os.Stdin, false)
 	if err != nil {
-		fmt.Println(err)
-		return subcommands.ExitFailure
+		return ep.fatal(err)
 	}
 
-	rdr.AddInfoToHeader(c.label+"_chr", "1", "String", "chromosome from "+c.label)
-	rdr.AddInfoToHeader(c.label+"_pos", "1", "Integer", "position from "+c.label)
+	if err := c.gvcf.begin(rdr.Header, ep.cmd); err != nil {
+		return ep.usage(err.Error())
+	}
+	chrID, posID := c.label+"_chr", c.label+"_pos"
+	if err := c.infos.add(rdr, chrID, "1", "String", "chromosome from "+c.label); err != nil {
+		return ep.fatal(err)
+	}
+	if err := c.infos.add(rdr, posID, "1", "Integer", "position from "+c.label); err != nil {
+		return ep.fatal(err)
+	}
 
+	addProvenance(rdr.Header, ep.cmd)
 	wrt, err := vcfgo.NewWriter(os.Stdout, rdr.Header)
 	if err != nil {
-		return subcommands.ExitFailure
+		return ep.fatal(err)
 	}
 
 	for {
@@ -834,9 +1120,20 @@ func (c *coords) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) s
 		if variant == nil {
 			break
 		}
+		st.RecordsIn++
+		if err := readErr(rdr); err != nil {
+			if !ep.record(variantWhere(variant), err) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+		if c.gvcf.skip(variant, wrt, st) {
+			continue
+		}
 
-		_ = variant.Info().Set(c.label+"_chr", variant.Chromosome)
-		_ = variant.Info().Set(c.label+"_pos", int(variant.Pos))
+		_ = variant.Info().Set(c.infos.id(chrID), variant.Chromosome)
+		_ = variant.Info().Set(c.infos.id(posID), int(variant.Pos))
+		st.RecordsOut++
 		wrt.WriteVariant(variant)
 	}
 	return subcommands.ExitSuccess
@@ -845,6 +1142,7 @@ func (c *coords) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) s
 type anchor struct {
 	character string
 	reference string
+	gvcf      gvcfBlocks
 }
 
 func (*anchor) Name() string { return "anchor" }
@@ -852,31 +1150,35 @@ func (*anchor) Synopsis() string {
 	return "remove charcter in vcf (*, -) and replace with anchored ref/alt"
 }
 func (*anchor) Usage() string {
-	return `anchor -character "*" -reference ref.fa`
+	return `anchor -character "*" -reference ref.fa [-gvcf pass|drop]`
 }
 
 func (a *anchor) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&a.character, "character", "*", "character to replace")
 	f.StringVar(&a.reference, "reference", "", "reference to get anchor base from")
+	a.gvcf.setFlags(f)
 }
 
-func (a *anchor) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+func (a *anchor) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := statsFrom(args)
+	ep := errPolicyFrom(args)
 	fa, err := faidx.New(a.reference)
 	if err != nil {
-		fmt.Println(err)
-		return subcommands.ExitFailure
+		return ep.fatal(err)
 	}
 
 	rdr, err := vcfgo.NewReader(os.Stdin, false)
 	if err != nil {
-		fmt.Println(err)
-		return subcommands.ExitFailure
+		return ep.fatal(err)
+	}
+	if err := a.gvcf.begin(rdr.Header, ep.cmd); err != nil {
+		return ep.usage(err.Error())
 	}
 
+	addProvenance(rdr.Header, ep.cmd)
 	wrt, err := vcfgo.NewWriter(os.Stdout, rdr.Header)
 	if err != nil {
-		fmt.Println(err)
-		return subcommands.ExitFailure
+		return ep.fatal(err)
 	}
 
 	for {
@@ -884,34 +1186,54 @@ func (a *anchor) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) s
 		if variant == nil {
 			break
 		}
+		st.RecordsIn++
+		if err := readErr(rdr); err != nil {
+			if !ep.record(variantWhere(variant), err) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+		if a.gvcf.skip(variant, wrt, st) {
+			continue
+		}
 		switch a.character {
 		case variant.Alt()[0]:
 			bp, err := fa.Get(variant.Chromosome, int(variant.Pos)-2, int(variant.Pos)-1)
 			if err != nil {
-				fmt.Println(err)
-				return subcommands.ExitFailure
+				if !ep.record(variantWhere(variant), err) {
+					return subcommands.ExitFailure
+				}
+				continue
 			}
 
 			variant.Pos = variant.Pos - 1
 			variant.Reference = bp + variant.Ref()
+			st.count("anchored")
 			variant.Alternate = []string{bp}
 			variant.Id_ = "."
 
+			st.RecordsOut++
 			wrt.WriteVariant(variant)
 
 		case variant.Reference:
 			bp, err := fa.Get(variant.Chromosome, int(variant.Pos)-2, int(variant.Pos)-1)
 			if err != nil {
-				panic(err)
+				if !ep.record(variantWhere(variant), err) {
+					return subcommands.ExitFailure
+				}
+				continue
 			}
 
 			variant.Pos = variant.Pos - 1
 			variant.Reference = bp
+			st.count("anchored")
 			variant.Alternate = []string{bp + variant.Alt()[0]}
 			variant.Id_ = "."
 
+			st.RecordsOut++
 			wrt.WriteVariant(variant)
 		default:
+			st.RecordsOut++
 			wrt.WriteVariant(variant)
 		}
 	}
@@ -919,24 +1241,38 @@ func (a *anchor) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) s
 }
 
 type mkVcf struct {
+	format string
+	chr    bool
 	//variants string
 	//pedigree string
 }
 
 func (*mkVcf) Name() string { return "mkVcf" }
 func (*mkVcf) Synopsis() string {
-	return "take variants in the format 1-3453452-G-A-sampleId with optional pedigree file and outputs vcf"
+	return "take variants in the format 1-3453452-G-A-sampleId (or chr1:3453452:G:A, chr1_3453452_G_A, NC_000001.11:g.3453452G>A) and outputs vcf"
 }
 func (*mkVcf) Usage() string {
-	return `mkVcf -variants /path/to/variants.txt -pedigree /path/to/pedigree.ped`
+	return `mkVcf [-format auto] [-chr] < variants.txt > out.vcf
+
+Reads one variant id per line, a sample may follow after whitespace or
+after the alt allele. -format auto tries hgvs, colon, underscore and dash
+in that order, gnomad (dash ids without a chr prefix) must be named.
+Genomic HGVS ids can only be substitutions and their RefSeq accessions
+become bare contig names (NC_000001.11 is 1), use -chr when the reference
+names contigs chr1 and chrM.
+`
 }
 
 func (v *mkVcf) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&v.format, "format", "auto", "variant id format (auto, "+strings.Join(varIDFormatNames(), ", ")+")")
+	f.BoolVar(&v.chr, "chr", false, "write contig names with a chr prefix, MT as chrM")
 	//f.StringVar(&v.variants, "variants", "", "list of variants to convert")
 	//f.StringVar(&v.pedigree, "pedigree", "", "pedigree file")
 }
 
-func (v *mkVcf) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+func (v *mkVcf) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := statsFrom(args)
+	ep := errPolicyFrom(args)
 	hdr := vcfgo.NewHeader()
 	hdr.FileFormat = "4.2"
 
@@ -947,9 +1283,10 @@ func (v *mkVcf) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) su
 		Type:        "String",
 	}
 
+	addProvenance(hdr, ep.cmd)
 	wrt, err := vcfgo.NewWriter(os.Stdout, hdr)
 	if err != nil {
-		panic(err)
+		return ep.fatal(err)
 	}
 
 	//type sv struct {
@@ -963,20 +1300,29 @@ func (v *mkVcf) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) su
 	//var vl []sv
 
 	scanner := bufio.NewScanner(os.Stdin)
+	n := 0
 	for scanner.Scan() {
-		if strings.Contains(scanner.Text(), "#") {
+		n++
+		if strings.Contains(scanner.Text(), "#") || strings.TrimSpace(scanner.Text()) == "" {
 			continue
 		}
 
-		ll := strings.Split(scanner.Text(), "-")
-		chrom := ll[0]
-		pos, err := strconv.Atoi(ll[1])
+		st.RecordsIn++
+		id, err := parseVarID(scanner.Text(), v.format)
 		if err != nil {
-			log.Fatal("could not convert position string to int, something is wrong with format")
+			if !ep.record(lineWhere("stdin", n), err) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+		chrom := id.chrom
+		if v.chr {
+			chrom = chrName(chrom)
 		}
-		ref := ll[2]
-		alt := ll[3]
-		sample := ll[4]
+		pos := id.pos
+		ref := id.ref
+		alt := id.alt
+		sample := id.sample
 
 		//cv := sv{
 		//	chromosome: chrom,
@@ -999,7 +1345,10 @@ func (v *mkVcf) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) su
 			Filter:     ".",
 			Info_:      vcfgo.NewInfoByte([]byte{}, hdr),
 		}
-		_ = variant.Info().Set("sample", sample)
+		if sample != "" {
+			_ = variant.Info().Set("sample", sample)
+		}
+		st.RecordsOut++
 		wrt.WriteVariant(variant)
 	}
 	return subcommands.ExitSuccess
@@ -1008,11 +1357,48 @@ func (v *mkVcf) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) su
 func mkCSQ(keys, vals []string) map[string]string {
 	csq := map[string]string{}
 	for i, k := range keys {
-		csq[k] = vals[i]
+		if i < len(vals) {
+			csq[k] = vals[i]
+		}
 	}
 	return csq
 }
 
+// getCSQKeys returns the CSQ subfield names from the VEP header description
+func getCSQKeys(h *vcfgo.Header) ([]string, error) {
+	csqH, ok := h.Infos["CSQ"]
+	if !ok {
+		return nil, fmt.Errorf("no CSQ field, please annotate with VEP")
+	}
+	_, format, ok := strings.Cut(csqH.Description, "Format: ")
+	if !ok {
+		return nil, fmt.Errorf("CSQ header has no Format: description")
+	}
+	return strings.Split(strings.Trim(format, `" `), "|"), nil
+}
+
+// getCSQ returns every CSQ entry of the variant, nil if it has none
+func getCSQ(v *vcfgo.Variant, keys []string) []map[string]string {
+	csq, err := v.Info().Get("CSQ")
+	if err != nil {
+		return nil
+	}
+
+	var entries []string
+	switch csq := csq.(type) {
+	case []string:
+		entries = csq
+	case string:
+		entries = strings.Split(csq, ",")
+	}
+
+	acsq := make([]map[string]string, 0, len(entries))
+	for _, c := range entries {
+		acsq = append(acsq, mkCSQ(keys, strings.Split(c, "|")))
+	}
+	return acsq
+}
+
 func getCanon(csq []map[string]string) []map[string]string {
 	rcsq := make([]map[string]string, 0)
 	for _, c := range csq {
@@ -1203,8 +1589,30 @@ func rankSevere(csq []map[string]string) map[string]string {
 	return tsl[0]
 }
 
+// csqChoice returns the indexes of the entries rankSevere and rankCanon pick,
+// -1 when there are no entries
+func csqChoice(csq []map[string]string) (int, int) {
+	severe, canon := -1, -1
+	if len(csq) == 0 {
+		return severe, canon
+	}
+	scsq := rankSevere(csq)
+	ccsq := rankCanon(csq)
+	for i, c := range csq {
+		if severe < 0 && reflect.DeepEqual(c, scsq) {
+			severe = i
+		}
+		if canon < 0 && reflect.DeepEqual(c, ccsq) {
+			canon = i
+		}
+	}
+	return severe, canon
+}
+
 type pullCSQ struct {
 	extract string
+	types   string
+	infos   infoFields
 }
 
 func (*pullCSQ) Name() string { return "pullCSQ" }
@@ -1212,84 +1620,128 @@ func (*pullCSQ) Synopsis() string {
 	return ""
 }
 func (*pullCSQ) Usage() string {
-	return `pullCSQ -extract csqField1,csqField2`
+	return `pullCSQ -extract csqField1,csqField2 [-types REVEL_score:Float,DOMAINS:String:.] [-overwrite | -suffix _new]`
 }
 
 func (p *pullCSQ) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&p.extract, "extract", "", "comma sep csq fields to extract")
+	f.StringVar(&p.types, "types", "", "comma sep field:Type[:Number] (Integer, Float or String; 1 or . for &-separated lists), otherwise inferred from the field name, scores and frequencies as Float keeping the largest of several values")
+	p.infos.setFlags(f)
 }
 
-func (p *pullCSQ) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+func (p *pullCSQ) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	st := statsFrom(args)
+	ep := errPolicyFrom(args)
 	// create VCF read, only read from stdin currently
 	rdr, err := vcfgo.NewReader(os.Stdin, false)
 	if err != nil {
-		panic(err)
+		return ep.fatal(err)
+	}
+
+	// get the csq key from the vcf header
+	csqKeys, err := getCSQKeys(rdr.Header)
+	if err != nil {
+		return ep.fatal(err)
 	}
 
 	// parse fields from argument into array of fields to extract from csq
+	if p.extract == "" {
+		return ep.usage("-extract needs at least one CSQ field")
+	}
+	known := map[string]bool{}
+	for _, k := range csqKeys {
+		known[k] = true
+	}
 	extractFields := strings.Split(p.extract, ",")
+	types, err := parseCSQTypes(p.types)
+	if err != nil {
+		return ep.usage(err.Error())
+	}
+	extracted := map[string]bool{}
 	for _, f := range extractFields {
-		rdr.AddInfoToHeader(f, "1", "String", "extracted from CSQ")
+		extracted[f] = true
+	}
+	for f := range types {
+		if !extracted[f] {
+			return ep.usage(fmt.Sprintf("-types gives %s, which is not in -extract", f))
+		}
 	}
-
 	for _, f := range extractFields {
-		rdr.AddInfoToHeader("canonical_" + f, "1", "String", "canonical " + f + " pulled from csq")
-		rdr.AddInfoToHeader(f, "1", "String", "most severe " + f + " pulled from csq")
+		if !known[f] {
+			return ep.usage(fmt.Sprintf("%s is not a CSQ field, the header lists %s", f, strings.Join(csqKeys, "|")))
+		}
+		t, ok := types[f]
+		if !ok {
+			t = inferCSQType(f)
+			types[f] = t
+		}
+		if err := p.infos.add(rdr, "canonical_"+f, t.number, t.typ, f+" of the canonical CSQ entry"); err != nil {
+			return ep.fatal(err)
+		}
+		if err := p.infos.add(rdr, f, t.number, t.typ, f+" of the most severe CSQ entry"); err != nil {
+			return ep.fatal(err)
+		}
 	}
 
 	// create writer
+	addProvenance(rdr.Header, ep.cmd)
 	wrt, err := vcfgo.NewWriter(os.Stdout, rdr.Header)
 	if err != nil {
-		panic(err)
-	}
-
-	// get the csq key from the vcf header
-	var csqInfo string
-	if csqH, ok := rdr.Header.Infos["CSQ"]; ok {
-		csqInfo = csqH.Description
-	} else {
-		log.Fatal("no CSQ field, please annotate with VEP")
+		return ep.fatal(err)
 	}
-	csqKeys := strings.Split(strings.Split(csqInfo, "Format: ")[1], "|")
-
 
+	omitted := map[string]int{}
 	for {
 		variant := rdr.Read()
 		if variant == nil {
 			break
 		}
-		csq, err := variant.Info().Get("CSQ")
-		if err != nil {
+		st.RecordsIn++
+		if err := readErr(rdr); err != nil {
+			if !ep.record(variantWhere(variant), err) {
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+		p.infos.clear(variant)
+		acsq := getCSQ(variant, csqKeys)
+		if len(acsq) == 0 {
+			st.count("no_csq")
+			st.RecordsOut++
 			wrt.WriteVariant(variant)
 			continue
 		}
 
-		var scsq map[string]string
-		var ccsq map[string]string
-
-		switch csq := csq.(type) {
-		case []string:
-			acsq :=make([]map[string]string, 0)
-			for _, c := range csq {
-				acsq = append(acsq, mkCSQ(csqKeys, strings.Split(c, "|")))
+		for _, c := range acsq {
+			// fewer values than the header format lists
+			if len(c) < len(csqKeys) {
+				st.count("csq_malformed")
 			}
-			scsq = rankSevere(acsq)
-			ccsq = rankCanon(acsq)
-		case string:
-			scsq = mkCSQ(csqKeys, strings.Split(csq, "|"))
-			ccsq = mkCSQ(csqKeys, strings.Split(csq, "|"))
 		}
 
-		for _, f := range extractFields {
-			if ccsq[f] != "" {
-				_ = variant.Info().Set("canonical_"+f, ccsq[f])
+		scsq := rankSevere(acsq)
+		ccsq := rankCanon(acsq)
+
+		// a value that does not convert is left out, the variant is kept
+		for _, cv := range csqValues(extractFields, types, ccsq, scsq) {
+			if cv.err != nil {
+				if omitted[cv.id] == 0 {
+					fmt.Fprintf(os.Stderr, "%s: %s: %s: %s, leaving %s out where it does not convert\n", ep.cmd, variantWhere(variant), cv.id, cv.err, cv.id)
+				}
+				omitted[cv.id]++
+				st.count("omitted " + cv.id)
+				continue
 			}
-			if scsq[f] != "" {
-				_ = variant.Info().Set(f, scsq[f])
+			if cv.val != nil {
+				_ = variant.Info().Set(p.infos.id(cv.id), cv.val)
 			}
 		}
+		st.RecordsOut++
 		wrt.WriteVariant(variant)
 	}
+	for id, n := range omitted {
+		fmt.Fprintf(os.Stderr, "%s: %s left out of %d records\n", ep.cmd, id, n)
+	}
 	return subcommands.ExitSuccess
 }
 
@@ -1297,14 +1749,28 @@ func main() {
 	subcommands.Register(subcommands.HelpCommand(), "")
 	subcommands.Register(subcommands.FlagsCommand(), "")
 	subcommands.Register(subcommands.CommandsCommand(), "")
-	subcommands.Register(&manipInfo{}, "")
-	subcommands.Register(&rank{}, "")
-	subcommands.Register(&anchor{}, "")
-	subcommands.Register(&psap2vcf{}, "")
-	subcommands.Register(&coords{}, "")
-	subcommands.Register(&filterCompHet{}, "")
-	subcommands.Register(&mkVcf{}, "")
-	subcommands.Register(&pullCSQ{}, "")
+	subcommands.Register(wrap(&manipInfo{}), "")
+	subcommands.Register(wrap(&rank{}), "")
+	subcommands.Register(wrap(&anchor{}), "")
+	subcommands.Register(wrap(&psap2vcf{}), "")
+	subcommands.Register(wrap(&coords{}), "")
+	subcommands.Register(wrap(&filterCompHet{}), "")
+	subcommands.Register(wrap(&mkVcf{}), "")
+	subcommands.Register(wrap(&pullCSQ{}), "")
+	subcommands.Register(wrap(&hgvs2vcf{}), "")
+	subcommands.Register(wrap(&toTable{}), "")
+	subcommands.Register(wrap(&toParquet{}), "")
+	subcommands.Register(wrap(&toSqlite{}), "")
+	subcommands.Register(wrap(&toJSON{}), "")
+	subcommands.Register(wrap(&fromJSON{}), "")
+	subcommands.Register(wrap(&gvcf2vcf{}), "")
+	subcommands.Register(wrap(&report{}), "")
+	subcommands.Register(wrap(&filter{}), "")
+	subcommands.Register(wrap(&script{}), "")
+	subcommands.Register(wrap(&acmg{}), "")
+	subcommands.Register(wrap(&clinvar{}), "")
+	subcommands.Register(wrap(&annotate{}), "")
+	subcommands.Register(wrap(&provenance{}), "")
 
 	flag.Parse()
 	ctx := context.Background()